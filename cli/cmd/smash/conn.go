@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evmar/smash/proto"
+	"github.com/gorilla/websocket"
+)
+
+// maxCellBacklog is how many bytes of a cell's output may be waiting to
+// be sent to the client before we stop reading more output from the
+// cell's command.
+const maxCellBacklog = 256 << 10
+
+// maxClientMessage bounds the size of a message from the client, which
+// is read into memory whole before parsing.
+const maxClientMessage = 16 << 20
+
+// helloTimeout is how long a new client has to send its ClientHello.
+// Clients built before the handshake existed never send one.
+const helloTimeout = 10 * time.Second
+
+var errConnClosed = errors.New("connection closed")
+
+// conn wraps a websocket.Conn with a queue of outgoing messages, so that
+// senders never block on a slow client.  A single goroutine writes the
+// queue to the websocket.
+//
+// When the client falls behind, terminal updates for a cell that are
+// still waiting in the queue are merged rather than queued again, and a
+// cell whose output backs up past maxCellBacklog is paused until the
+// client catches up.
+type conn struct {
+	ws *websocket.Conn
+
+	mu   sync.Mutex // protects the fields below
+	wake *sync.Cond
+	// queue holds messages not yet handed to the websocket.
+	queue []*outMsg
+	// backlog counts the bytes of output queued or being written, by cell.
+	backlog map[int]int
+	// err is set once the connection is closed or a write failed.
+	err error
+}
+
+// outMsg is a queued message, already encoded.
+type outMsg struct {
+	buf []byte
+	// cell is the cell the message is output for, or -1.
+	cell int
+	// update is set if the message is a TermUpdate, which may be merged
+	// with later updates while it waits.
+	update *proto.TermUpdate
+}
+
+func newConn(ws *websocket.Conn) *conn {
+	ws.SetReadLimit(maxClientMessage)
+	c := &conn{ws: ws, backlog: map[int]int{}}
+	c.wake = sync.NewCond(&c.mu)
+	go c.writeLoop()
+	return c
+}
+
+// handshake reads the client's ClientHello, and refuses a client built
+// from a different schema, whose messages we can't read.
+func (c *conn) handshake() error {
+	c.ws.SetReadDeadline(time.Now().Add(helloTimeout))
+	_, buf, err := c.ws.ReadMessage()
+	if err != nil {
+		c.refuse("expected a client hello; reload the page to update the client")
+		return fmt.Errorf("reading client hello: %s", err)
+	}
+	c.ws.SetReadDeadline(time.Time{})
+	var hello proto.ClientHello
+	if err := hello.Read(bufio.NewReader(bytes.NewBuffer(buf))); err != nil {
+		c.refuse("bad client hello; reload the page to update the client")
+		return fmt.Errorf("parsing client hello: %s", err)
+	}
+	if hello.Schema != proto.SchemaHash {
+		// Close reasons are limited to 123 bytes, so trim the client's hash.
+		err := fmt.Errorf("protocol mismatch (client %.16s, server %s)", hello.Schema, proto.SchemaHash)
+		c.refuse(err.Error() + "; reload the page to update the client")
+		return err
+	}
+	return nil
+}
+
+// refuse closes the connection, with a reason for the client to show.
+func (c *conn) refuse(reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	c.close()
+}
+
+func encodeMsg(msg proto.Msg) ([]byte, error) {
+	m := &proto.ServerMsg{Alt: msg}
+	w := &bytes.Buffer{}
+	if err := m.Write(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// writeMsg queues a message for the client.  It only fails if the
+// connection is gone.
+func (c *conn) writeMsg(msg proto.Msg) error {
+	out := &outMsg{cell: -1}
+	if co, ok := msg.(*proto.CellOutput); ok {
+		out.cell = co.Cell
+		out.update, _ = co.Output.Alt.(*proto.TermUpdate)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if out.update != nil {
+		if prev := c.pendingUpdate(out.cell); prev != nil {
+			return c.merge(prev, out.update)
+		}
+	}
+	buf, err := encodeMsg(msg)
+	if err != nil {
+		return err
+	}
+	out.buf = buf
+	c.queue = append(c.queue, out)
+	if out.cell >= 0 {
+		c.backlog[out.cell] += len(buf)
+	}
+	c.wake.Broadcast()
+	return nil
+}
+
+// pendingUpdate finds a queued update for cell that no other message for
+// the cell follows, and which so can absorb a newer update.
+// Called with c.mu held.
+func (c *conn) pendingUpdate(cell int) *outMsg {
+	for i := len(c.queue) - 1; i >= 0; i-- {
+		if out := c.queue[i]; out.cell == cell {
+			if out.update == nil {
+				return nil
+			}
+			return out
+		}
+	}
+	return nil
+}
+
+// merge folds update into the queued update prev.
+// Called with c.mu held.
+func (c *conn) merge(prev *outMsg, update *proto.TermUpdate) error {
+	merged := mergeUpdates(prev.update, update)
+	buf, err := encodeMsg(&proto.CellOutput{
+		Cell:   prev.cell,
+		Output: proto.Output{Alt: merged},
+	})
+	if err != nil {
+		return err
+	}
+	c.backlog[prev.cell] += len(buf) - len(prev.buf)
+	prev.buf = buf
+	prev.update = merged
+	return nil
+}
+
+// mergeUpdates combines two terminal updates into one with the effect of
+// applying both in order.
+func mergeUpdates(old, new *proto.TermUpdate) *proto.TermUpdate {
+	merged := *new
+	merged.Trimmed = old.Trimmed + new.Trimmed
+	rows := map[int]proto.RowSpans{}
+	for _, r := range old.Rows {
+		// Renumber the older rows past any lines trimmed since, and drop
+		// those trimmed or past the new end of the terminal.
+		r.Row -= new.Trimmed
+		if r.Row >= 0 && r.Row < new.RowCount {
+			rows[r.Row] = r
+		}
+	}
+	for _, r := range new.Rows {
+		rows[r.Row] = r
+	}
+	merged.Rows = make([]proto.RowSpans, 0, len(rows))
+	for _, r := range rows {
+		merged.Rows = append(merged.Rows, r)
+	}
+	// The client expects rows in order.
+	sort.Slice(merged.Rows, func(i, j int) bool {
+		return merged.Rows[i].Row < merged.Rows[j].Row
+	})
+	return &merged
+}
+
+// writeLoop sends queued messages until the connection fails or closes.
+func (c *conn) writeLoop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		for len(c.queue) == 0 && c.err == nil {
+			c.wake.Wait()
+		}
+		if c.err != nil {
+			return
+		}
+		out := c.queue[0]
+		c.queue = c.queue[1:]
+
+		c.mu.Unlock()
+		err := c.ws.WriteMessage(websocket.BinaryMessage, out.buf)
+		c.mu.Lock()
+
+		if out.cell >= 0 {
+			c.backlog[out.cell] -= len(out.buf)
+		}
+		c.wake.Broadcast()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// waitBacklog blocks while the client is behind on cell's output.
+func (c *conn) waitBacklog(cell int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.backlog[cell] > maxCellBacklog && c.err == nil {
+		c.wake.Wait()
+	}
+}
+
+// close shuts down the connection, dropping any queued messages.
+func (c *conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fail(errConnClosed)
+}
+
+// fail records the connection's failure and wakes everything waiting on it.
+// Called with c.mu held.
+func (c *conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+		c.ws.Close()
+	}
+	c.queue = nil
+	c.wake.Broadcast()
+}
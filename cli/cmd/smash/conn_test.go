@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/evmar/smash/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// row returns a row of output with the given text.
+func row(n int, text string) proto.RowSpans {
+	return proto.RowSpans{Row: n, Spans: []proto.Span{{Text: text}}}
+}
+
+func TestMergeUpdates(t *testing.T) {
+	old := &proto.TermUpdate{
+		Rows:     []proto.RowSpans{row(1, "a"), row(2, "b"), row(3, "c")},
+		RowCount: 4,
+		Trimmed:  1,
+	}
+	new := &proto.TermUpdate{
+		Rows:     []proto.RowSpans{row(0, "B"), row(4, "e")},
+		Cursor:   proto.Cursor{Row: 4, Col: 1},
+		RowCount: 5,
+	}
+	merged := mergeUpdates(old, new)
+	assert.Equal(t, []proto.RowSpans{row(0, "B"), row(1, "a"), row(2, "b"), row(3, "c"), row(4, "e")}, merged.Rows)
+	assert.Equal(t, new.Cursor, merged.Cursor)
+	assert.Equal(t, 5, merged.RowCount)
+	assert.Equal(t, 1, merged.Trimmed)
+}
+
+func TestMergeUpdatesTrimmed(t *testing.T) {
+	// Lines trimmed between the two updates renumber the older rows, and
+	// rows trimmed away are dropped.
+	old := &proto.TermUpdate{
+		Rows:     []proto.RowSpans{row(0, "a"), row(1, "b"), row(2, "c")},
+		RowCount: 3,
+		Trimmed:  1,
+	}
+	new := &proto.TermUpdate{
+		Rows:     []proto.RowSpans{row(2, "d")},
+		RowCount: 3,
+		Trimmed:  2,
+	}
+	merged := mergeUpdates(old, new)
+	assert.Equal(t, []proto.RowSpans{row(0, "c"), row(2, "d")}, merged.Rows)
+	assert.Equal(t, 3, merged.Trimmed)
+}
+
+func TestMergeUpdatesShrink(t *testing.T) {
+	// Rows past the new end of the terminal are dropped.
+	old := &proto.TermUpdate{
+		Rows:     []proto.RowSpans{row(0, "a"), row(3, "d"), row(4, "e")},
+		RowCount: 5,
+	}
+	new := &proto.TermUpdate{
+		Rows:     []proto.RowSpans{row(2, "C")},
+		RowCount: 3,
+	}
+	merged := mergeUpdates(old, new)
+	assert.Equal(t, []proto.RowSpans{row(0, "a"), row(2, "C")}, merged.Rows)
+	assert.Equal(t, 3, merged.RowCount)
+}
+
+// newTestConn returns a conn with no websocket or writer, so that
+// messages stay queued.
+func newTestConn() *conn {
+	c := &conn{backlog: map[int]int{}}
+	c.wake = sync.NewCond(&c.mu)
+	return c
+}
+
+func TestConnMergesUpdates(t *testing.T) {
+	c := newTestConn()
+	update := func(cell int, rows ...proto.RowSpans) {
+		err := c.writeMsg(&proto.CellOutput{
+			Cell:   cell,
+			Output: proto.Output{Alt: &proto.TermUpdate{Rows: rows, RowCount: 3}},
+		})
+		assert.NoError(t, err)
+	}
+
+	update(0, row(0, "a"))
+	update(1, row(0, "x"))
+	// Merged into the first update, past the other cell's.
+	update(0, row(1, "b"))
+	assert.Equal(t, 2, len(c.queue))
+	assert.Equal(t, []proto.RowSpans{row(0, "a"), row(1, "b")}, c.queue[0].update.Rows)
+
+	// A RowsResponse answers a request made against the rows as of the
+	// updates before it, so later updates can't merge across it.
+	assert.NoError(t, c.writeMsg(&proto.CellOutput{
+		Cell:   0,
+		Output: proto.Output{Alt: &proto.RowsResponse{Rows: []proto.RowSpans{row(0, "a")}}},
+	}))
+	update(0, row(2, "c"))
+	assert.Equal(t, 4, len(c.queue))
+	assert.Equal(t, []proto.RowSpans{row(0, "a"), row(1, "b")}, c.queue[0].update.Rows)
+	assert.Nil(t, c.queue[2].update)
+	assert.Equal(t, []proto.RowSpans{row(2, "c")}, c.queue[3].update.Rows)
+
+	// The backlog counts the merged messages as sent.
+	total := 0
+	for _, out := range c.queue {
+		if out.cell == 0 {
+			total += len(out.buf)
+		}
+	}
+	assert.Equal(t, total, c.backlog[0])
+}
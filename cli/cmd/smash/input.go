@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxPendingInput bounds the input buffered for a command that isn't
+// reading it.  Past this, input is refused rather than queued.
+const maxPendingInput = 64 << 10
+
+var (
+	errInputFull   = errors.New("input dropped: command is not reading its input")
+	errInputClosed = errors.New("input dropped: command has exited")
+)
+
+// inputQueue buffers input for a command, so that writing to a command
+// that isn't reading never blocks the sender.
+type inputQueue struct {
+	mu   sync.Mutex
+	wake *sync.Cond
+	// buf is the input not yet written to the command.
+	buf []byte
+	// err is set once no more input will be accepted.
+	err error
+}
+
+func newInputQueue() *inputQueue {
+	q := &inputQueue{}
+	q.wake = sync.NewCond(&q.mu)
+	return q
+}
+
+// write queues input for the command.  It fails if the command has
+// exited or has too much input pending.
+func (q *inputQueue) write(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	if len(q.buf)+len(data) > maxPendingInput {
+		return errInputFull
+	}
+	q.buf = append(q.buf, data...)
+	q.wake.Signal()
+	return nil
+}
+
+// close stops accepting input, with err as the reason given to writers.
+// err replaces any earlier write error, as once the command has exited
+// its exit better explains why the write failed.
+func (q *inputQueue) close(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+	q.buf = nil
+	q.wake.Signal()
+}
+
+// copyTo writes queued input to w until the queue is closed or a write
+// fails.
+func (q *inputQueue) copyTo(w io.Writer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for len(q.buf) == 0 && q.err == nil {
+			q.wake.Wait()
+		}
+		if q.err != nil {
+			return
+		}
+		buf := q.buf
+		q.buf = nil
+		// Release the lock during the write, which blocks if the
+		// command isn't reading.
+		q.mu.Unlock()
+		_, err := w.Write(buf)
+		q.mu.Lock()
+		if err != nil {
+			if q.err == nil {
+				q.err = err
+			}
+			return
+		}
+	}
+}
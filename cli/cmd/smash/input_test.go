@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputQueue(t *testing.T) {
+	q := newInputQueue()
+	r, w := io.Pipe()
+	go q.copyTo(w)
+
+	assert.NoError(t, q.write([]byte("abc")))
+	buf := make([]byte, 3)
+	_, err := io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(buf))
+
+	q.close(errInputClosed)
+	assert.Equal(t, errInputClosed, q.write([]byte("x")))
+}
+
+func TestInputQueueFull(t *testing.T) {
+	// Nothing copies from the queue, as if the command weren't reading.
+	q := newInputQueue()
+	assert.NoError(t, q.write(make([]byte, maxPendingInput-1)))
+	assert.NoError(t, q.write([]byte("x")))
+	assert.Equal(t, errInputFull, q.write([]byte("x")))
+
+	// Closing drops the pending input.
+	q.close(errInputClosed)
+	assert.Nil(t, q.buf)
+	assert.Equal(t, errInputClosed, q.write([]byte("x")))
+}
+
+// failWriter passes each write to writes, and fails all but the first.
+type failWriter struct {
+	writes chan string
+	n      int
+}
+
+var errTestWrite = errors.New("write /dev/ptmx: input/output error")
+
+func (w *failWriter) Write(buf []byte) (int, error) {
+	w.writes <- string(buf)
+	if w.n++; w.n > 1 {
+		return 0, errTestWrite
+	}
+	return len(buf), nil
+}
+
+func TestInputQueueWriteError(t *testing.T) {
+	q := newInputQueue()
+	w := &failWriter{writes: make(chan string)}
+	done := make(chan bool)
+	go func() {
+		q.copyTo(w)
+		done <- true
+	}()
+
+	assert.NoError(t, q.write([]byte("a")))
+	assert.Equal(t, "a", <-w.writes)
+	assert.NoError(t, q.write([]byte("b")))
+	assert.Equal(t, "b", <-w.writes)
+	// copyTo stops after the failed write.
+	<-done
+	assert.Equal(t, errTestWrite, q.write([]byte("c")))
+
+	// Once the command exits, that is the reason given.
+	q.close(errInputClosed)
+	assert.Equal(t, errInputClosed, q.write([]byte("d")))
+}
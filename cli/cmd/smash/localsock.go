@@ -1,39 +1,195 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strings"
+	"sync"
 	"syscall"
+
+	"github.com/evmar/smash/proto"
 )
 
-// handleLocal handles an incoming local connection,
-// by reading a command from the connection and writing
-// its output to it.
-func handleLocal(conn net.Conn) error {
+// localCmd is a subcommand run by `smash <name> args...` within a cell.
+// Its standard streams are connected to the `smash` process over the
+// local socket.
+type localCmd struct {
+	// session is the session of the cell the command was run from.
+	session *session
+	args    []string
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// flags returns a FlagSet for the command's arguments that reports
+// errors to its stderr.
+func (c *localCmd) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("smash "+name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// writeFrame writes msg to w with a single Write, so that a message is
+// never split by another writer.
+func writeFrame(w io.Writer, msg proto.Msg) error {
+	buf := &bytes.Buffer{}
+	if err := msg.Write(buf); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// localOutput is an output stream of a local command, which forwards
+// writes to the connection as LocalOutput messages.
+type localOutput struct {
+	mu     *sync.Mutex // shared by stdout and stderr
+	conn   net.Conn
+	stderr bool
+}
+
+func (o *localOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	msg := &proto.LocalServerMsg{Alt: &proto.LocalOutput{Stderr: o.stderr, Data: p}}
+	if err := writeFrame(o.conn, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readLocalStdin copies the LocalStdin messages following a request
+// into w, until end of file.
+func readLocalStdin(r *bufio.Reader, w *io.PipeWriter) {
+	for {
+		var msg proto.LocalClientMsg
+		if err := msg.Read(r); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		in, ok := msg.Alt.(*proto.LocalStdin)
+		if !ok {
+			w.CloseWithError(fmt.Errorf("expected stdin, got %T", msg.Alt))
+			return
+		}
+		if len(in.Data) == 0 {
+			w.Close()
+			return
+		}
+		if _, err := w.Write(in.Data); err != nil {
+			// The command stopped reading.
+			return
+		}
+	}
+}
+
+// handleLocal handles an incoming local connection to a session's socket,
+// by reading a request from the connection, running the command with its
+// standard streams forwarded over the connection, and sending its exit
+// status.
+func handleLocal(conn net.Conn, s *session) error {
 	defer conn.Close()
-	var buf [1 << 10]byte
-	n, err := conn.Read(buf[:])
-	if err != nil {
+	r := bufio.NewReader(conn)
+	var msg proto.LocalClientMsg
+	if err := msg.Read(r); err != nil {
 		return err
 	}
-	cmd := strings.TrimSpace(string(buf[:n]))
-	cmdFn := localCommands[cmd]
-	if cmdFn != nil {
-		return cmdFn(conn)
+	req, ok := msg.Alt.(*proto.LocalRequest)
+	if !ok {
+		return fmt.Errorf("expected a local request, got %T", msg.Alt)
+	}
+
+	stdin, stdinW := io.Pipe()
+	defer stdin.Close()
+	go readLocalStdin(r, stdinW)
+
+	mu := &sync.Mutex{}
+	c := &localCmd{
+		session: s,
+		args:    req.Args,
+		stdin:   stdin,
+		stdout:  &localOutput{mu: mu, conn: conn},
+		stderr:  &localOutput{mu: mu, conn: conn, stderr: true},
+	}
+	code := 127
+	if fn := localCommands[req.Command]; fn != nil {
+		code = fn(c)
+	} else {
+		fmt.Fprintf(c.stderr, "smash: unknown command %q\n", req.Command)
+	}
+	return writeFrame(conn, &proto.LocalServerMsg{Alt: &proto.LocalExit{Code: code}})
+}
+
+// sendLocalStdin forwards our stdin to the server as LocalStdin messages.
+func sendLocalStdin(conn net.Conn) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			msg := &proto.LocalClientMsg{Alt: &proto.LocalStdin{Data: buf[:n]}}
+			if writeFrame(conn, msg) != nil {
+				return
+			}
+		}
+		if err != nil {
+			writeFrame(conn, &proto.LocalClientMsg{Alt: &proto.LocalStdin{}})
+			return
+		}
 	}
-	_, err = io.WriteString(conn, "bad command\n")
-	return err
 }
 
-// getSockPath gets a (hopefully unique) path for storing the smash socket.
+// runLocal runs `smash <name> args...` by sending it to the smash server
+// over $SMASH_SOCK, and returns its exit status.
+func runLocal(name string, args []string) (int, error) {
+	sockPath := os.Getenv("SMASH_SOCK")
+	if sockPath == "" {
+		return 0, fmt.Errorf("no $SMASH_SOCK; are you running under smash?")
+	}
+
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	req := &proto.LocalClientMsg{Alt: &proto.LocalRequest{Command: name, Args: args}}
+	if err := writeFrame(conn, req); err != nil {
+		return 0, err
+	}
+	go sendLocalStdin(conn)
+
+	r := bufio.NewReader(conn)
+	for {
+		var msg proto.LocalServerMsg
+		if err := msg.Read(r); err != nil {
+			return 0, err
+		}
+		switch msg := msg.Alt.(type) {
+		case *proto.LocalOutput:
+			out := os.Stdout
+			if msg.Stderr {
+				out = os.Stderr
+			}
+			if _, err := out.Write(msg.Data); err != nil {
+				return 0, err
+			}
+		case *proto.LocalExit:
+			return msg.Code, nil
+		}
+	}
+}
+
+// getSockPath gets a (hopefully unique) path for storing the smash socket
+// of the session with the given id.
 // (Note that the path doesn't need to be predictable across invocations,
 // as the socket path is passed to subcommands via the environment.)
-func getSockPath() (string, error) {
+func getSockPath(id string) (string, error) {
 	path := os.Getenv("XDG_RUNTIME_DIR")
 	if path == "" {
 		var err error
@@ -47,7 +203,7 @@ func getSockPath() (string, error) {
 		return "", err
 	}
 
-	sockName := fmt.Sprintf("sock.%d", os.Getpid())
+	sockName := fmt.Sprintf("sock.%d.%s", os.Getpid(), id)
 	path = filepath.Join(path, sockName)
 	if _, err := os.Stat(path); err != nil && !os.IsNotExist(err) {
 		if err := os.Remove(path); err != nil {
@@ -58,33 +214,63 @@ func getSockPath() (string, error) {
 	return path, nil
 }
 
-// deleteOnExit attempts to delete the given path when you ctl-c.
+// exitPaths holds the paths to delete when you ctl-c.
+var exitPaths struct {
+	sync.Mutex
+	paths []string
+	once  sync.Once
+}
+
+// deleteOnExit attempts to delete the given path, and anything under it,
+// when you ctl-c.
 func deleteOnExit(path string) {
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		os.Remove(path)
-		os.Exit(128 + int(syscall.SIGTERM))
-	}()
+	exitPaths.Lock()
+	defer exitPaths.Unlock()
+	exitPaths.paths = append(exitPaths.paths, path)
+	exitPaths.once.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			exitPaths.Lock()
+			for _, path := range exitPaths.paths {
+				os.RemoveAll(path)
+			}
+			os.Exit(128 + int(syscall.SIGTERM))
+		}()
+	})
 }
 
-// setupLocalCommandSock creates the listening local command socket, and
-// returns its path and the socket.
-func setupLocalCommandSock() (string, net.Listener, error) {
-	path, err := getSockPath()
+// cancelDeleteOnExit forgets a path passed to deleteOnExit, once it has
+// been deleted some other way.
+func cancelDeleteOnExit(path string) {
+	exitPaths.Lock()
+	defer exitPaths.Unlock()
+	for i, p := range exitPaths.paths {
+		if p == path {
+			exitPaths.paths = append(exitPaths.paths[:i], exitPaths.paths[i+1:]...)
+			return
+		}
+	}
+}
+
+// setupLocalCommandSock creates the listening local command socket for
+// the session with the given id, and returns its path and the socket.
+func setupLocalCommandSock(id string) (string, net.Listener, error) {
+	path, err := getSockPath(id)
 	if err != nil {
 		return "", nil, err
 	}
 	l, err := net.Listen("unix", path)
-	if err != nil {
+	if err == nil {
 		deleteOnExit(path)
 	}
 	return path, l, err
 }
 
-// readLocalCommands loops forever, reading commands from the local socket.
-func readLocalCommands(sock net.Listener) error {
+// readLocalCommands loops forever, reading commands from a session's local
+// socket.
+func readLocalCommands(sock net.Listener, s *session) error {
 	defer sock.Close()
 	for {
 		conn, err := sock.Accept()
@@ -92,7 +278,7 @@ func readLocalCommands(sock net.Listener) error {
 			return err
 		}
 		go func() {
-			err := handleLocal(conn)
+			err := handleLocal(conn, s)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "local conn: %s\n", err)
 			}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/evmar/smash/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleLocal(t *testing.T) {
+	localCommands["test-cat"] = func(c *localCmd) int {
+		io.WriteString(c.stderr, c.args[0])
+		io.Copy(c.stdout, c.stdin)
+		return 3
+	}
+	defer delete(localCommands, "test-cat")
+
+	client, server := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- handleLocal(server, newTestSession(t))
+	}()
+
+	go func() {
+		for _, msg := range []proto.Msg{
+			&proto.LocalRequest{Command: "test-cat", Args: []string{"arg"}},
+			&proto.LocalStdin{Data: []byte("hello, ")},
+			&proto.LocalStdin{Data: []byte("world")},
+			&proto.LocalStdin{},
+		} {
+			if err := writeFrame(client, &proto.LocalClientMsg{Alt: msg}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	var stdout, stderr []byte
+	r := bufio.NewReader(client)
+	for {
+		var msg proto.LocalServerMsg
+		if err := msg.Read(r); err != nil {
+			t.Fatal(err)
+		}
+		if out, ok := msg.Alt.(*proto.LocalOutput); ok {
+			if out.Stderr {
+				stderr = append(stderr, out.Data...)
+			} else {
+				stdout = append(stdout, out.Data...)
+			}
+			continue
+		}
+		assert.Equal(t, &proto.LocalExit{Code: 3}, msg.Alt)
+		break
+	}
+	assert.Equal(t, "hello, world", string(stdout))
+	assert.Equal(t, "arg", string(stderr))
+
+	// The server closes the connection after the exit status.
+	_, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, <-done)
+}
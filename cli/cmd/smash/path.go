@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pathCache remembers the files found in $PATH directories, so that
+// looking up a command doesn't rescan every directory each time.
+// A directory is rescanned when its modification time changes, which
+// happens whenever entries are added to or removed from it.  Changing a
+// file's mode doesn't touch the directory, so whether a file is
+// executable is checked when it is looked up, not cached.
+type pathCache struct {
+	mu   sync.Mutex
+	dirs map[string]*pathDir
+}
+
+// pathDir is the scanned contents of one $PATH directory.
+type pathDir struct {
+	mtime time.Time
+	// names is the set of files in the directory.
+	names map[string]bool
+}
+
+var globalPathCache = &pathCache{dirs: map[string]*pathDir{}}
+
+// scan returns the files in dir, using the cache if it is current.
+// A missing or unreadable directory has no files.
+func (c *pathCache) scan(dir string) *pathDir {
+	st, err := os.Stat(dir)
+	if err != nil || !st.IsDir() {
+		return &pathDir{}
+	}
+
+	c.mu.Lock()
+	d := c.dirs[dir]
+	c.mu.Unlock()
+	if d != nil && d.mtime.Equal(st.ModTime()) {
+		return d
+	}
+
+	d = &pathDir{mtime: st.ModTime(), names: map[string]bool{}}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return d
+	}
+	for _, e := range entries {
+		// Keep symlinks whatever their target, which may change without
+		// touching dir.
+		if !e.IsDir() {
+			d.names[e.Name()] = true
+		}
+	}
+
+	c.mu.Lock()
+	c.dirs[dir] = d
+	c.mu.Unlock()
+	return d
+}
+
+// pathDirs splits a $PATH value into its directories.  Empty entries
+// conventionally mean the current directory, but like exec.LookPath we
+// don't run commands from relative paths.
+func pathDirs(path string) []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(path) {
+		if filepath.IsAbs(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// isExecutable reports whether path is, or links to, an executable file.
+func isExecutable(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.Mode().IsRegular() && st.Mode()&0111 != 0
+}
+
+// lookPath finds the executable for name in the directories of path.
+func (c *pathCache) lookPath(name, path string) (string, error) {
+	for _, dir := range pathDirs(path) {
+		if c.scan(dir).names[name] {
+			if p := filepath.Join(dir, name); isExecutable(p) {
+				return p, nil
+			}
+		}
+	}
+	return "", &notFoundError{name: name, suggestions: c.suggest(name, path)}
+}
+
+// suggest returns commands in path with names similar to name, most
+// similar first.
+func (c *pathCache) suggest(name, path string) []string {
+	maxDist := len(name) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	dists := map[string]int{}
+	for _, dir := range pathDirs(path) {
+		for cand := range c.scan(dir).names {
+			if _, seen := dists[cand]; seen {
+				continue
+			}
+			if d := editDistance(name, cand); d <= maxDist && isExecutable(filepath.Join(dir, cand)) {
+				dists[cand] = d
+			}
+		}
+	}
+	var suggestions []string
+	for cand := range dists {
+		suggestions = append(suggestions, cand)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if dists[a] != dists[b] {
+			return dists[a] < dists[b]
+		}
+		return a < b
+	})
+	if len(suggestions) > 3 {
+		suggestions = suggestions[:3]
+	}
+	return suggestions
+}
+
+// shellCommandName returns the command that argv, a `/bin/sh -c` script
+// as the client wraps command lines in, runs, if the script is a simple
+// command whose name the shell looks up in $PATH; otherwise it returns "".
+func shellCommandName(argv []string) string {
+	if len(argv) != 3 || argv[0] != "/bin/sh" || argv[1] != "-c" {
+		return ""
+	}
+	script := argv[2]
+	// Leave anything running more than one command to the shell.
+	if strings.ContainsAny(script, ";&|()`\n") {
+		return ""
+	}
+	words := strings.Fields(script)
+	if len(words) == 0 {
+		return ""
+	}
+	name := words[0]
+	// Variable assignments, paths and quoted or expanded names aren't
+	// looked up as they are.
+	if strings.ContainsAny(name, "=/'\"\\$*?[~{") {
+		return ""
+	}
+	return name
+}
+
+// notFoundError is the error for a command missing from $PATH.
+type notFoundError struct {
+	name        string
+	suggestions []string
+}
+
+func (e *notFoundError) Error() string {
+	msg := fmt.Sprintf("%s: command not found", e.name)
+	if len(e.suggestions) > 0 {
+		msg += fmt.Sprintf("; did you mean %s?", strings.Join(e.suggestions, ", "))
+	}
+	return msg
+}
+
+// editDistance computes the edit distance between two strings, counting
+// insertions, deletions, substitutions and transpositions of adjacent
+// characters, the common typos.
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j].
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if t := d[i-2][j-2] + 1; t < d[i][j] {
+					d[i][j] = t
+				}
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// envLookup returns the value of key in env, a list of "key=value"
+// strings as from os.Environ.  Later entries take precedence.
+func envLookup(env []string, key string) string {
+	val := ""
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			val = kv[len(key)+1:]
+		}
+	}
+	return val
+}
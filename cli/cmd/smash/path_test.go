@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		dist int
+	}{
+		{"", "", 0},
+		{"ls", "ls", 0},
+		{"", "ls", 2},
+		{"ls", "l", 1},
+		{"gti", "git", 1},
+		{"grpe", "grep", 1},
+		{"mkae", "make", 1},
+		{"pyhton", "python", 1},
+		{"cat", "cut", 1},
+		{"kitten", "sitting", 3},
+		{"ab", "ba", 1},
+		{"abc", "ca", 3},
+	} {
+		assert.Equal(t, test.dist, editDistance(test.a, test.b), "%q %q", test.a, test.b)
+		assert.Equal(t, test.dist, editDistance(test.b, test.a), "%q %q", test.b, test.a)
+	}
+}
+
+// testPathDir creates a directory containing the named files with the
+// given modes.
+func testPathDir(t *testing.T, files map[string]os.FileMode) string {
+	dir, err := ioutil.TempDir("", "smash-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLookPath(t *testing.T) {
+	dir1 := testPathDir(t, map[string]os.FileMode{"git": 0755, "grep": 0755, "notes": 0644})
+	defer os.RemoveAll(dir1)
+	dir2 := testPathDir(t, map[string]os.FileMode{"git": 0755, "gitk": 0755, "notes": 0755})
+	defer os.RemoveAll(dir2)
+	if err := os.Symlink(filepath.Join(dir1, "grep"), filepath.Join(dir2, "egrep")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir2, "gitdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := "relative:" + dir1 + ":" + dir2
+
+	c := &pathCache{dirs: map[string]*pathDir{}}
+	for _, test := range []struct {
+		name string
+		path string
+		err  string
+	}{
+		// Earlier directories win.
+		{"git", filepath.Join(dir1, "git"), ""},
+		{"gitk", filepath.Join(dir2, "gitk"), ""},
+		// Non-executable files are skipped.
+		{"notes", filepath.Join(dir2, "notes"), ""},
+		{"egrep", filepath.Join(dir2, "egrep"), ""},
+		// Directories aren't commands.
+		{"gitdir", "", "gitdir: command not found"},
+		{"gti", "", "gti: command not found; did you mean git?"},
+		{"gitj", "", "gitj: command not found; did you mean git, gitk?"},
+		{"zzz", "", "zzz: command not found"},
+	} {
+		p, err := c.lookPath(test.name, path)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.name)
+		} else {
+			assert.NoError(t, err, test.name)
+		}
+		assert.Equal(t, test.path, p, test.name)
+	}
+
+	assert.Equal(t, []string{"egrep"}, c.suggest("egrpe", path))
+	assert.Equal(t, []string(nil), c.suggest("gti", "relative"))
+
+	// Non-executable files aren't suggested.
+	if err := os.Chmod(filepath.Join(dir2, "gitk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"git"}, c.suggest("gitj", path))
+}
+
+func TestPathCacheInvalidation(t *testing.T) {
+	dir := testPathDir(t, nil)
+	defer os.RemoveAll(dir)
+	tool := filepath.Join(dir, "tool")
+	c := &pathCache{dirs: map[string]*pathDir{}}
+	// setMtime gives dir a distinct modification time, as the
+	// filesystem's timestamps may be too coarse to show a change.
+	mtime := time.Now()
+	setMtime := func() {
+		mtime = mtime.Add(time.Second)
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setMtime()
+
+	_, err := c.lookPath("tool", dir)
+	assert.EqualError(t, err, "tool: command not found")
+
+	// A new file changes the directory's mtime.
+	if err := ioutil.WriteFile(tool, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setMtime()
+	_, err = c.lookPath("tool", dir)
+	assert.EqualError(t, err, "tool: command not found")
+
+	// Making it executable doesn't.
+	st, _ := os.Stat(dir)
+	if err := os.Chmod(tool, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dir, st.ModTime(), st.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.lookPath("tool", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, tool, p)
+
+	if err := os.Remove(tool); err != nil {
+		t.Fatal(err)
+	}
+	setMtime()
+	_, err = c.lookPath("tool", dir)
+	assert.EqualError(t, err, "tool: command not found")
+}
+
+func TestShellCommandName(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		name   string
+	}{
+		{"gti status", "gti"},
+		{"  make -j4", "make"},
+		{"", ""},
+		{"./configure", ""},
+		{"CC=clang make", ""},
+		{"$EDITOR x.go", ""},
+		{"'git' log", ""},
+		{"cd src; gti", ""},
+		{"gti log | less", ""},
+	} {
+		assert.Equal(t, test.name, shellCommandName([]string{"/bin/sh", "-c", test.script}), test.script)
+	}
+	assert.Equal(t, "", shellCommandName([]string{"gti", "status"}))
+}
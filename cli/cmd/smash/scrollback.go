@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/evmar/smash/proto"
+	"github.com/evmar/smash/vt100"
+)
+
+// defaultScrollback is the number of scrollback lines a terminal keeps
+// when the client doesn't ask for a specific limit.
+const defaultScrollback = 10000
+
+// scrollbackSlack is how far past its limit a terminal's scrollback may
+// grow before it is trimmed, so that lines are trimmed in batches rather
+// than one at a time.
+const scrollbackSlack = 1000
+
+// scrollbackStore holds lines trimmed from a terminal in a temporary
+// file, as gzipped chunks of encoded RowSpans, so the client can page
+// back through them without the server holding them in memory.
+//
+// The file is only held open while the command is running and adding
+// lines; once it finishes, reads open the file for themselves, so that
+// finished commands don't each keep a file descriptor.  The file is
+// deleted when the command's session is evicted, or with the rest of
+// scrollbackDir when the server is interrupted.
+type scrollbackStore struct {
+	path string
+	// f is the file open for writing, or nil once finished.
+	f      *os.File
+	chunks []scrollbackChunk
+	// lines is the number of lines stored.
+	lines int
+	// size is the size of the file.
+	size int64
+}
+
+// scrollbackChunk locates one batch of lines in a scrollbackStore file.
+type scrollbackChunk struct {
+	// start is the line number of the first line in the chunk.
+	start, count int
+	offset, size int64
+}
+
+// scrollbackDir is the directory holding this server's scrollback
+// files, created on first use.
+var scrollbackDir struct {
+	sync.Mutex
+	path string
+}
+
+func newScrollbackStore() (*scrollbackStore, error) {
+	scrollbackDir.Lock()
+	defer scrollbackDir.Unlock()
+	if scrollbackDir.path == "" {
+		dir, err := ioutil.TempDir("", fmt.Sprintf("smash-scrollback.%d.", os.Getpid()))
+		if err != nil {
+			return nil, err
+		}
+		deleteOnExit(dir)
+		scrollbackDir.path = dir
+	}
+	f, err := ioutil.TempFile(scrollbackDir.path, "cell")
+	if err != nil {
+		return nil, err
+	}
+	return &scrollbackStore{path: f.Name(), f: f}, nil
+}
+
+// add appends rows, which must be numbered following the lines already
+// stored.
+func (s *scrollbackStore) add(rows []proto.RowSpans) error {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	for i := range rows {
+		if rows[i].Row != s.lines+i {
+			return fmt.Errorf("scrollback: got row %d, want %d", rows[i].Row, s.lines+i)
+		}
+		if err := rows[i].Write(zw); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if s.f == nil {
+		f, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		s.f = f
+	}
+	if _, err := s.f.WriteAt(buf.Bytes(), s.size); err != nil {
+		return err
+	}
+	s.chunks = append(s.chunks, scrollbackChunk{
+		start:  s.lines,
+		count:  len(rows),
+		offset: s.size,
+		size:   int64(buf.Len()),
+	})
+	s.lines += len(rows)
+	s.size += int64(buf.Len())
+	return nil
+}
+
+// finish closes the file once no more lines will be added.  It can
+// still be read.
+func (s *scrollbackStore) finish() {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+}
+
+// reader returns a reader for the lines stored so far, which may be used
+// without synchronizing with later adds.
+func (s *scrollbackStore) reader() *scrollbackReader {
+	// Chunks are only appended, so the slice as of now stays valid.
+	return &scrollbackReader{path: s.path, chunks: s.chunks, lines: s.lines}
+}
+
+// read returns the stored lines [start, end).
+func (s *scrollbackStore) read(start, end int) ([]proto.RowSpans, error) {
+	return s.reader().read(start, end)
+}
+
+// close deletes the store's file.
+func (s *scrollbackStore) close() {
+	s.finish()
+	os.Remove(s.path)
+}
+
+// scrollbackReader reads the lines of a scrollbackStore as of when it
+// was created.
+type scrollbackReader struct {
+	path   string
+	chunks []scrollbackChunk
+	// lines is the number of lines readable.
+	lines int
+}
+
+// read returns the stored lines [start, end).
+func (r *scrollbackReader) read(start, end int) ([]proto.RowSpans, error) {
+	if start < 0 || end > r.lines || start > end {
+		return nil, fmt.Errorf("scrollback: bad range [%d, %d) of %d lines", start, end, r.lines)
+	}
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rows []proto.RowSpans
+	for _, c := range r.chunks {
+		if c.start+c.count <= start || c.start >= end {
+			continue
+		}
+		zr, err := gzip.NewReader(io.NewSectionReader(f, c.offset, c.size))
+		if err != nil {
+			return nil, err
+		}
+		br := bufio.NewReader(zr)
+		for i := 0; i < c.count && c.start+i < end; i++ {
+			var row proto.RowSpans
+			if err := row.Read(br); err != nil {
+				return nil, err
+			}
+			if c.start+i >= start {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// rowCells turns a stored row back into terminal cells, as needed to
+// map its text to columns.  Attributes are not restored.
+func rowCells(row proto.RowSpans) []vt100.Cell {
+	var cells []vt100.Cell
+	for _, span := range row.Spans {
+		for _, r := range span.Text {
+			switch vt100.RuneWidth(r) {
+			case 0:
+				if len(cells) > 0 {
+					i := len(cells) - 1
+					if cells[i].Ch == 0 && i > 0 {
+						i-- // second half of a wide character
+					}
+					cells[i].Combining += string(r)
+				}
+			case 2:
+				cells = append(cells, vt100.Cell{Ch: r}, vt100.Cell{})
+			default:
+				cells = append(cells, vt100.Cell{Ch: r})
+			}
+		}
+	}
+	return cells
+}
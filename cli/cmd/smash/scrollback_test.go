@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/evmar/smash/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRows returns rows [start, end) with their numbers as text.
+func testRows(start, end int) []proto.RowSpans {
+	var rows []proto.RowSpans
+	for i := start; i < end; i++ {
+		rows = append(rows, row(i, fmt.Sprint(i)))
+	}
+	return rows
+}
+
+func TestScrollbackStore(t *testing.T) {
+	s, err := newScrollbackStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	assert.NoError(t, s.add(testRows(0, 3)))
+	assert.NoError(t, s.add(testRows(3, 5)))
+	assert.EqualError(t, s.add(testRows(9, 10)), "scrollback: got row 9, want 5")
+
+	// A reader sees the lines as of its creation.
+	r := s.reader()
+	assert.NoError(t, s.add(testRows(5, 6)))
+	rows, err := r.read(2, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, testRows(2, 5), rows)
+	_, err = r.read(2, 6)
+	assert.EqualError(t, err, "scrollback: bad range [2, 6) of 5 lines")
+
+	// Once finished the file isn't held open, but can still be read,
+	// and reopened to add more.
+	s.finish()
+	assert.Nil(t, s.f)
+	rows, err = s.read(0, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, testRows(0, 6), rows)
+	assert.NoError(t, s.add(testRows(6, 7)))
+	rows, err = s.read(5, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, testRows(5, 7), rows)
+
+	s.close()
+	_, err = os.Stat(s.path)
+	assert.True(t, os.IsNotExist(err))
+}
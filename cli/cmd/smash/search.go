@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/evmar/smash/proto"
+	"github.com/evmar/smash/vt100"
+)
+
+// maxSearchMatches limits the matches returned for one search.
+const maxSearchMatches = 1000
+
+// searchPattern compiles the query of a search request.
+func searchPattern(req *proto.SearchRequest) (*regexp.Regexp, error) {
+	if req.Query == "" {
+		return nil, fmt.Errorf("empty search")
+	}
+	expr := req.Query
+	if !req.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if req.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// lineText returns the text of a terminal line, along with the offset in
+// the text at which each cell's text starts.
+func lineText(l []vt100.Cell) (string, []int) {
+	var text strings.Builder
+	offsets := make([]int, len(l))
+	for i, cell := range l {
+		offsets[i] = text.Len()
+		cell.WriteText(&text)
+	}
+	return text.String(), offsets
+}
+
+// matchColumns converts a match at text offsets [start, end) of a line
+// into the range of columns it covers.
+func matchColumns(l []vt100.Cell, offsets []int, start, end int) (int, int) {
+	startCol := 0
+	for startCol+1 < len(l) && offsets[startCol+1] <= start {
+		startCol++
+	}
+	endCol := startCol
+	for endCol < len(l) && offsets[endCol] < end {
+		endCol++
+	}
+	// Include the second half of a wide character.
+	for endCol < len(l) && l[endCol].Ch == 0 {
+		endCol++
+	}
+	return startCol, endCol
+}
+
+// search finds matches for a search request in the terminals of the
+// session's commands.
+func (s *session) search(req *proto.SearchRequest) *proto.SearchResponse {
+	resp := &proto.SearchResponse{Id: req.Id}
+	re, err := searchPattern(req)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	cells := map[int]bool{}
+	for _, cell := range req.Cells {
+		cells[cell] = true
+	}
+	for _, cmd := range s.sortedCommands() {
+		if len(cells) > 0 && !cells[cmd.req.Cell] {
+			continue
+		}
+		full, err := cmd.searchLines(re, resp)
+		if err != nil {
+			resp.Error = fmt.Sprintf("cell %d: %s", cmd.req.Cell, err)
+			break
+		}
+		if full {
+			resp.Truncated = true
+			break
+		}
+	}
+	return resp
+}
+
+// searchLine appends matches in one line of a command's output to
+// matches, up to maxSearchMatches, and reports whether it stopped early
+// because matches is full.
+func searchLine(re *regexp.Regexp, l []vt100.Cell, match proto.SearchMatch, matches *[]proto.SearchMatch) bool {
+	text, offsets := lineText(l)
+	for _, m := range re.FindAllStringIndex(text, -1) {
+		if m[0] == m[1] {
+			// Empty matches aren't useful to show.
+			continue
+		}
+		if len(*matches) == maxSearchMatches {
+			return true
+		}
+		match.Start, match.End = matchColumns(l, offsets, m[0], m[1])
+		match.Before = text[:m[0]]
+		match.Match = text[m[0]:m[1]]
+		match.After = text[m[1]:]
+		*matches = append(*matches, match)
+	}
+	return false
+}
+
+// searchLines appends matches in the command's output, first those in
+// its scrollback history and then those in its terminal, to resp, and
+// reports whether it stopped early because resp is full.
+func (cmd *command) searchLines(re *regexp.Regexp, resp *proto.SearchResponse) (bool, error) {
+	// Search the terminal under the lock, but the history, which must be
+	// read from disk, without it, so as not to stall the command's output.
+	var history *scrollbackReader
+	var termMatches []proto.SearchMatch
+	cmd.mu.Lock()
+	if cmd.history != nil {
+		history = cmd.history.reader()
+	}
+	for row, l := range cmd.term.Lines {
+		match := proto.SearchMatch{Cell: cmd.req.Cell, Row: row}
+		if searchLine(re, l, match, &termMatches) {
+			break
+		}
+	}
+	cmd.mu.Unlock()
+
+	if history != nil {
+		for start := 0; start < history.lines; start += maxScrollbackPage {
+			end := start + maxScrollbackPage
+			if end > history.lines {
+				end = history.lines
+			}
+			rows, err := history.read(start, end)
+			if err != nil {
+				return false, err
+			}
+			for _, row := range rows {
+				match := proto.SearchMatch{Cell: cmd.req.Cell, Row: row.Row, History: true}
+				if searchLine(re, rowCells(row), match, &resp.Matches) {
+					return true, nil
+				}
+			}
+		}
+	}
+	for _, match := range termMatches {
+		if len(resp.Matches) == maxSearchMatches {
+			return true, nil
+		}
+		resp.Matches = append(resp.Matches, match)
+	}
+	return false, nil
+}
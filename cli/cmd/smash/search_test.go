@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/evmar/smash/proto"
+	"github.com/evmar/smash/vt100"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSession(t *testing.T, outputs ...string) *session {
+	s := &session{commands: map[int]*command{}}
+	for i, output := range outputs {
+		cmd := newCmd(s, &proto.RunRequest{Cell: i, Argv: []string{"test"}})
+		tr := vt100.NewTermReader(func(f func(t *vt100.Terminal)) {
+			f(cmd.term)
+		})
+		feed(t, tr, output)
+		s.addCommand(cmd)
+	}
+	return s
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestSession(t, "ok\r\nerror: x.go\r\n", "中error: y\r\nERROR\r\n")
+	resp := s.search(&proto.SearchRequest{Query: "error: "})
+	assert.Equal(t, "", resp.Error)
+	assert.Equal(t, []proto.SearchMatch{
+		{Cell: 0, Row: 1, Start: 0, End: 7, Match: "error: ", After: "x.go"},
+		{Cell: 1, Row: 0, Start: 2, End: 9, Before: "中", Match: "error: ", After: "y"},
+	}, resp.Matches)
+
+	resp = s.search(&proto.SearchRequest{Query: "error", IgnoreCase: true, Cells: []int{1}})
+	assert.Equal(t, 2, len(resp.Matches))
+	assert.Equal(t, "ERROR", resp.Matches[1].Match)
+
+	// Wide characters cover two columns.
+	resp = s.search(&proto.SearchRequest{Query: "中", Regex: true})
+	assert.Equal(t, 0, resp.Matches[0].Start)
+	assert.Equal(t, 2, resp.Matches[0].End)
+
+	// Literal searches don't treat the query as a pattern.
+	resp = s.search(&proto.SearchRequest{Query: "x.go"})
+	assert.Equal(t, 1, len(resp.Matches))
+	resp = s.search(&proto.SearchRequest{Query: "x.go("})
+	assert.Equal(t, 0, len(resp.Matches))
+	resp = s.search(&proto.SearchRequest{Query: "x.go(", Regex: true})
+	assert.Contains(t, resp.Error, "missing closing )")
+}
+
+func TestSearchHistory(t *testing.T) {
+	var output strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&output, "line %d\r\n", i)
+	}
+	output.WriteString("中error: old\r\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&output, "line %d\r\n", i)
+	}
+	output.WriteString("error: new\r\n")
+	s := newTestSession(t, output.String())
+	cmd := s.command(0)
+	dirty := vt100.TermDirty{Lines: map[int]bool{}}
+	if err := cmd.storeHistory(cmd.term.TrimScrollback(&dirty, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := s.search(&proto.SearchRequest{Query: "error: "})
+	assert.Equal(t, "", resp.Error)
+	// History comes first, numbered within the history.
+	assert.Equal(t, []proto.SearchMatch{
+		{Cell: 0, Row: 50, History: true, Start: 2, End: 9, Before: "中", Match: "error: ", After: "old"},
+		{Cell: 0, Row: cmd.term.Height - 2, Start: 0, End: 7, Match: "error: ", After: "new"},
+	}, resp.Matches)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evmar/smash/proto"
+)
+
+// sessionIdleTimeout is how long a session is kept with no client
+// attached and no commands running, for a client to reattach to.
+const sessionIdleTimeout = 30 * time.Minute
+
+// session is the server-side state of a client shell: the commands it
+// spawned and their terminals.  A session outlives any single websocket
+// connection, so a client that reloads can reattach and pick up where it
+// left off.  A session left idle is evicted after sessionIdleTimeout.
+type session struct {
+	id string
+	// registry is the registry holding the session, which evicts it once
+	// idle.  It is nil for sessions outside any registry.
+	registry *sessionRegistry
+	// sockPath is the session's local command socket, passed to its
+	// commands as $SMASH_SOCK, or empty if it couldn't be created.
+	sockPath string
+	sock     net.Listener
+
+	mu sync.Mutex // protects the fields below
+	// conn is the attached client, or nil if no client is attached.
+	conn     *conn
+	commands map[int]*command
+	// finished holds the session's finished commands, in the order they
+	// finished.
+	finished []*command
+	// running counts the commands that haven't finished.
+	running int
+	// idleSince is when the session last became idle, or zero while it
+	// has a client or running commands.  idle fires to evict it.
+	idleSince time.Time
+	idle      *time.Timer
+	// closed is set once the session is evicted.
+	closed bool
+}
+
+// sessionRegistry tracks all sessions on this server by id.
+type sessionRegistry struct {
+	sync.Mutex
+	sessions map[string]*session
+}
+
+var globalSessions = &sessionRegistry{sessions: map[string]*session{}}
+
+func newSessionID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// get looks up the session with the given id, creating a new session if
+// there is no such session.
+func (r *sessionRegistry) get(id string) *session {
+	r.Lock()
+	defer r.Unlock()
+	if s := r.sessions[id]; s != nil {
+		return s
+	}
+	s := &session{
+		id:       newSessionID(),
+		registry: r,
+		commands: map[int]*command{},
+	}
+	if err := s.listenLocal(); err != nil {
+		log.Printf("session %s: local sock: %s", s.id, err)
+	}
+	r.sessions[s.id] = s
+	// Idle until a client attaches, in case it never does.
+	s.mu.Lock()
+	s.checkIdle()
+	s.mu.Unlock()
+	return s
+}
+
+// evictIfIdle removes s from the registry and closes it, if it has been
+// idle for sessionIdleTimeout.
+func (r *sessionRegistry) evictIfIdle(s *session) {
+	r.Lock()
+	s.mu.Lock()
+	// A client may have attached since the timer fired, or the timer may
+	// be from an earlier idle period.
+	evict := !s.idleSince.IsZero() && time.Since(s.idleSince) >= sessionIdleTimeout
+	if evict {
+		delete(r.sessions, s.id)
+	}
+	s.mu.Unlock()
+	r.Unlock()
+	if evict {
+		log.Printf("session %s: evicting idle session", s.id)
+		s.close()
+	}
+}
+
+// checkIdle starts the eviction timer if the session has become idle,
+// and stops it if the session is in use.
+// Called with s.mu held.
+func (s *session) checkIdle() {
+	if s.registry == nil || s.closed {
+		return
+	}
+	if s.conn != nil || s.running > 0 {
+		s.idleSince = time.Time{}
+		if s.idle != nil {
+			s.idle.Stop()
+			s.idle = nil
+		}
+		return
+	}
+	if s.idle == nil {
+		s.idleSince = time.Now()
+		s.idle = time.AfterFunc(sessionIdleTimeout, func() {
+			s.registry.evictIfIdle(s)
+		})
+	}
+}
+
+// close releases the resources held by an evicted session: its local
+// socket, and the scrollback files of its commands.
+func (s *session) close() {
+	s.mu.Lock()
+	s.closed = true
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	cmds := make([]*command, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		cmds = append(cmds, cmd)
+	}
+	s.mu.Unlock()
+	if s.sock != nil {
+		// Closing the listener also removes the socket file.
+		s.sock.Close()
+		cancelDeleteOnExit(s.sockPath)
+	}
+	for _, cmd := range cmds {
+		cmd.close()
+	}
+}
+
+// isClosed reports whether the session has been evicted.
+func (s *session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// listenLocal creates the session's local command socket and starts
+// serving it, so that `smash` subcommands run in the session's cells
+// find the session.
+func (s *session) listenLocal() error {
+	path, l, err := setupLocalCommandSock(s.id)
+	if err != nil {
+		return err
+	}
+	s.sockPath = path
+	s.sock = l
+	go func() {
+		if err := readLocalCommands(l, s); err != nil && !s.isClosed() {
+			log.Printf("session %s: local sock: %s", s.id, err)
+		}
+	}()
+	return nil
+}
+
+// send forwards a message to the attached client, if any.
+// A failed write means the client went away; the session keeps running
+// and the client can catch up when it reattaches.
+func (s *session) send(msg proto.Msg) error {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	if err := c.writeMsg(msg); err != nil {
+		log.Printf("session %s: dropping client: %s", s.id, err)
+		s.detach(c)
+	}
+	return nil
+}
+
+// waitBacklog blocks while the attached client, if any, is behind on
+// cell's output.
+func (s *session) waitBacklog(cell int) {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c != nil {
+		c.waitBacklog(cell)
+	}
+}
+
+func (s *session) addCommand(cmd *command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[cmd.req.Cell] = cmd
+	s.running++
+	s.checkIdle()
+}
+
+// finish records that cmd finished.
+func (s *session) finish(cmd *command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = append(s.finished, cmd)
+	s.running--
+	s.checkIdle()
+}
+
+// finishedCommands returns the session's finished commands, in the order
+// they finished.
+func (s *session) finishedCommands() []*command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*command{}, s.finished...)
+}
+
+func (s *session) command(cell int) *command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands[cell]
+}
+
+// sortedCommands returns the session's commands ordered by cell id.
+func (s *session) sortedCommands() []*command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmds := make([]*command, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].req.Cell < cmds[j].req.Cell
+	})
+	return cmds
+}
+
+// attach makes c the session's client, replacing any previous client.
+// It fills in hello with the state of all cells and sends it to c.
+func (s *session) attach(c *conn, hello *proto.Hello) error {
+	// Hold every command's lock while snapshotting, so that no output
+	// is sent between the snapshot and the switch to the new client.
+	cmds := s.sortedCommands()
+	for _, cmd := range cmds {
+		cmd.mu.Lock()
+		defer cmd.mu.Unlock()
+	}
+
+	hello.Session = s.id
+	for _, cmd := range cmds {
+		hello.Cells = append(hello.Cells, cmd.state())
+	}
+	if err := c.writeMsg(hello); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	old := s.conn
+	s.conn = c
+	s.checkIdle()
+	s.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// detach disconnects c from the session, if it is still the attached client.
+func (s *session) detach(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == c {
+		s.conn = nil
+		s.checkIdle()
+	}
+}
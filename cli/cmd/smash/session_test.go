@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/evmar/smash/proto"
+	"github.com/evmar/smash/vt100"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smash-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+
+	r := &sessionRegistry{sessions: map[string]*session{}}
+	s := r.get("")
+	assert.Equal(t, s, r.get(s.id))
+	_, err = os.Stat(s.sockPath)
+	assert.NoError(t, err)
+	// idle backdates the session's idle period past the timeout, as if
+	// its timer had fired.
+	idle := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.idleSince.IsZero() {
+			return false
+		}
+		s.idleSince = s.idleSince.Add(-sessionIdleTimeout)
+		return true
+	}
+
+	// A session is in use while a client is attached or a command runs.
+	c := newTestConn()
+	assert.NoError(t, s.attach(c, &proto.Hello{}))
+	assert.False(t, idle())
+	cmd := newCmd(s, &proto.RunRequest{Cell: 0, Argv: []string{"test"}})
+	s.addCommand(cmd)
+	s.detach(c)
+	assert.False(t, idle())
+	// Give the command some history in a scrollback file.
+	cmd.mu.Lock()
+	assert.NoError(t, cmd.storeHistory([][]vt100.Cell{{{Ch: 'a'}}}))
+	history := cmd.history
+	cmd.mu.Unlock()
+	s.finish(cmd)
+
+	// A timer from an earlier idle period doesn't evict it early.
+	r.evictIfIdle(s)
+	assert.Equal(t, s, r.sessions[s.id])
+
+	assert.True(t, idle())
+	r.evictIfIdle(s)
+	assert.Nil(t, r.sessions[s.id])
+	assert.True(t, s.isClosed())
+	_, err = os.Stat(s.sockPath)
+	assert.True(t, os.IsNotExist(err))
+	// Its commands' scrollback files are deleted.
+	assert.Nil(t, cmd.history)
+	assert.Nil(t, history.f)
+	_, err = os.Stat(history.path)
+	assert.True(t, os.IsNotExist(err))
+	_, err = cmd.readScrollback(0, 1)
+	assert.EqualError(t, err, "no scrollback stored")
+
+	// Reattaching with the old id gets a new session.
+	assert.NotEqual(t, s, r.get(s.id))
+}
@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,8 +25,6 @@ import (
 )
 
 var completer *bash.Bash
-var globalLastTermForCmd *vt100.Terminal
-var globalSockPathForEnv string
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:    1024,
@@ -33,23 +32,6 @@ var upgrader = websocket.Upgrader{
 	EnableCompression: true,
 }
 
-// conn wraps a websocket.Conn with a lock.
-type conn struct {
-	sync.Mutex
-	ws *websocket.Conn
-}
-
-func (c *conn) writeMsg(msg proto.Msg) error {
-	m := &proto.ServerMsg{msg}
-	w := &bytes.Buffer{}
-	if err := m.Write(w); err != nil {
-		return err
-	}
-	c.Lock()
-	defer c.Unlock()
-	return c.ws.WriteMessage(websocket.BinaryMessage, w.Bytes())
-}
-
 // isPtyEOFError tests for a pty close error.
 // When a pty closes, you get an EIO error instead of an EOF.
 func isPtyEOFError(err error) bool {
@@ -66,37 +48,470 @@ func isPtyEOFError(err error) bool {
 // command represents a subprocess running on behalf of the user.
 // req.Cell has the id of the command for use in protocol messages.
 type command struct {
-	conn *conn
+	session *session
 	// req is the initial request that caused the command to be spawned.
 	req *proto.RunRequest
 	cmd *exec.Cmd
 
-	// stdin accepts input keys and forwards them to the subprocess.
-	stdin chan []byte
+	// input buffers keys and other input for the subprocess.
+	input *inputQueue
+
+	// mu protects the fields below, and is held while sending output
+	// so that output is ordered with respect to session.attach.
+	mu   sync.Mutex
+	term *vt100.Terminal
+	// tr and pty are set while the subprocess is running.
+	tr  *vt100.TermReader
+	pty *os.File
+	// pid is the subprocess id once started, kept until the command is
+	// marked exited.
+	pid int
+	// scrollback is the number of scrollback lines kept in term.
+	scrollback int
+	// history stores lines trimmed from term, created on first use.
+	// It is nil if trimmed lines are being dropped.
+	history     *scrollbackStore
+	dropHistory bool
+	// err is the error reported if the command failed to run.
+	err string
+	// exited is set once the command completes, along with how it exited.
+	exited bool
+	exit   proto.Exit
 }
 
-func newCmd(conn *conn, req *proto.RunRequest) *command {
+func newCmd(s *session, req *proto.RunRequest) *command {
 	cmd := &exec.Cmd{Path: req.Argv[0], Args: req.Argv}
-	// TODO: accept environment from the client
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, "SMASH_SOCK="+globalSockPathForEnv)
+	cmd.Env = commandEnv(os.Environ(), req.SetEnv, req.UnsetEnv)
+	cmd.Env = append(cmd.Env, "SMASH_SOCK="+s.sockPath)
 	cmd.Dir = req.Cwd
+	term := vt100.NewTerminal()
+	if req.Size.Rows > 0 && req.Size.Cols > 0 {
+		size := clampSize(req.Size)
+		term.Width = size.Cols
+		term.Height = size.Rows
+	}
+	scrollback := req.Scrollback
+	if scrollback <= 0 {
+		scrollback = defaultScrollback
+	}
 	return &command{
-		conn: conn,
-		req:  req,
-		cmd:  cmd,
+		session:     s,
+		req:         req,
+		cmd:         cmd,
+		input:       newInputQueue(),
+		term:        term,
+		scrollback:  scrollback,
+		dropHistory: req.DropScrollback,
+	}
+}
+
+// trimScrollback removes lines past the scrollback limit from the
+// terminal, storing them in the command's history unless they are being
+// dropped.  It returns the number of lines removed.
+// Called with cmd.mu held.
+func (cmd *command) trimScrollback(dirty *vt100.TermDirty) int {
+	if cmd.term.Top < cmd.scrollback+scrollbackSlack {
+		return 0
+	}
+	lines := cmd.term.TrimScrollback(dirty, cmd.scrollback)
+	if len(lines) > 0 && !cmd.dropHistory {
+		if err := cmd.storeHistory(lines); err != nil {
+			// Keep going without history rather than failing the command.
+			log.Printf("cell %d: dropping scrollback: %s", cmd.req.Cell, err)
+			if cmd.history != nil {
+				cmd.history.close()
+				cmd.history = nil
+			}
+			cmd.dropHistory = true
+		}
+	}
+	return len(lines)
+}
+
+// storeHistory appends lines trimmed from the terminal to the history.
+// Called with cmd.mu held.
+func (cmd *command) storeHistory(lines [][]vt100.Cell) error {
+	if cmd.history == nil {
+		h, err := newScrollbackStore()
+		if err != nil {
+			return err
+		}
+		cmd.history = h
+	}
+	rows := make([]proto.RowSpans, len(lines))
+	for i, l := range lines {
+		rows[i] = rowSpans(cmd.history.lines+i, l)
+	}
+	return cmd.history.add(rows)
+}
+
+// historyLines returns the number of lines in the command's history.
+// Called with cmd.mu held.
+func (cmd *command) historyLines() int {
+	if cmd.history == nil {
+		return 0
 	}
+	return cmd.history.lines
 }
 
+// close deletes the command's history, once its session is evicted.
+func (cmd *command) close() {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	if cmd.history != nil {
+		cmd.history.close()
+		cmd.history = nil
+	}
+	cmd.dropHistory = true
+}
+
+// maxScrollbackPage limits the lines returned for one ScrollbackRequest
+// or RowsRequest.
+const maxScrollbackPage = 1000
+
+// sendRows sends the client rows [start, end) of the terminal, or fewer
+// if the range is larger than maxScrollbackPage or extends outside the
+// terminal.
+func (cmd *command) sendRows(start, end int) error {
+	if start < 0 {
+		start = 0
+	}
+	if end-start > maxScrollbackPage {
+		end = start + maxScrollbackPage
+	}
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	if end > len(cmd.term.Lines) {
+		end = len(cmd.term.Lines)
+	}
+	resp := &proto.RowsResponse{}
+	for row := start; row < end; row++ {
+		resp.Rows = append(resp.Rows, rowSpans(row, cmd.term.Lines[row]))
+	}
+	return cmd.send(resp)
+}
+
+// readScrollback returns lines [start, end) of the command's history,
+// or fewer if the range is larger than maxScrollbackPage or extends past
+// the stored lines.
+func (cmd *command) readScrollback(start, end int) ([]proto.RowSpans, error) {
+	if end-start > maxScrollbackPage {
+		end = start + maxScrollbackPage
+	}
+	cmd.mu.Lock()
+	if cmd.history == nil {
+		cmd.mu.Unlock()
+		return nil, fmt.Errorf("no scrollback stored")
+	}
+	// Read without holding the lock, which would stall the command's
+	// output.
+	history := cmd.history.reader()
+	cmd.mu.Unlock()
+	if end > history.lines {
+		end = history.lines
+	}
+	return history.read(start, end)
+}
+
+// commandEnv applies the client's environment overrides to the base
+// environment, a list of "key=value" strings as from os.Environ.
+func commandEnv(base []string, set []proto.Pair, unset []string) []string {
+	drop := map[string]bool{}
+	for _, key := range unset {
+		drop[key] = true
+	}
+	for _, p := range set {
+		drop[p.Key] = true
+	}
+	env := []string{}
+	for _, kv := range base {
+		key := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			key = kv[:i]
+		}
+		if !drop[key] {
+			env = append(env, kv)
+		}
+	}
+	for _, p := range set {
+		env = append(env, p.Key+"="+p.Val)
+	}
+	return env
+}
+
+// maxTermSize bounds the rows and columns of a terminal, far beyond any
+// real screen.  Client sizes are clamped to it, so that the terminal's
+// allocations stay small and the size fits the pty's 16-bit fields.
+const maxTermSize = 1000
+
+// clampSize limits a client's terminal size to maxTermSize.
+func clampSize(size proto.TermSize) proto.TermSize {
+	if size.Rows > maxTermSize {
+		size.Rows = maxTermSize
+	}
+	if size.Cols > maxTermSize {
+		size.Cols = maxTermSize
+	}
+	return size
+}
+
+// resize changes the terminal size of a running command.
+func (cmd *command) resize(size proto.TermSize) error {
+	if size.Rows <= 0 || size.Cols <= 0 {
+		return fmt.Errorf("bad terminal size %dx%d", size.Cols, size.Rows)
+	}
+	size = clampSize(size)
+	cmd.mu.Lock()
+	tr, f := cmd.tr, cmd.pty
+	cmd.mu.Unlock()
+	if tr == nil {
+		return fmt.Errorf("resize: command not running")
+	}
+
+	// Update our view of the terminal before the subprocess learns
+	// of the new size, so its redraw lands in the resized terminal.
+	tr.WithTerm(func(t *vt100.Terminal) {
+		t.Resize(&tr.Dirty, size.Cols, size.Rows)
+	})
+	// Setting the size delivers SIGWINCH to the pty's foreground
+	// process group.
+	return pty.Setsize(f, &pty.Winsize{
+		Rows: uint16(size.Rows),
+		Cols: uint16(size.Cols),
+	})
+}
+
+// mouse forwards a mouse event to the command, if the command asked for
+// mouse reporting.
+func (cmd *command) mouse(msg *proto.MouseEvent) error {
+	cmd.mu.Lock()
+	buf := cmd.term.EncodeMouse(vt100.MouseEvent{
+		Action: vt100.MouseAction(msg.Action),
+		Button: int(msg.Button),
+		// The client counts rows from the top of the scrollback.
+		Row:   msg.Row - cmd.term.Top,
+		Col:   msg.Col,
+		Shift: msg.Shift,
+		Alt:   msg.Alt,
+		Ctrl:  msg.Ctrl,
+	})
+	cmd.mu.Unlock()
+	if buf == nil {
+		return nil
+	}
+	return cmd.input.write(buf)
+}
+
+// paste sends pasted text to the command.
+func (cmd *command) paste(text string) error {
+	cmd.mu.Lock()
+	buf := cmd.term.EncodePaste(text)
+	cmd.mu.Unlock()
+	return cmd.input.write(buf)
+}
+
+// inputError reports input that couldn't be delivered to the command.
+func (cmd *command) inputError(err error) {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	cmd.send(&proto.InputError{Error: err.Error()})
+}
+
+// signalsByName are the signals a client may send to a command.
+var signalsByName = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
+
+// signalNames maps signal numbers to their conventional names, without
+// the SIG prefix.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:    "HUP",
+	syscall.SIGINT:    "INT",
+	syscall.SIGQUIT:   "QUIT",
+	syscall.SIGILL:    "ILL",
+	syscall.SIGTRAP:   "TRAP",
+	syscall.SIGABRT:   "ABRT",
+	syscall.SIGBUS:    "BUS",
+	syscall.SIGFPE:    "FPE",
+	syscall.SIGKILL:   "KILL",
+	syscall.SIGUSR1:   "USR1",
+	syscall.SIGSEGV:   "SEGV",
+	syscall.SIGUSR2:   "USR2",
+	syscall.SIGPIPE:   "PIPE",
+	syscall.SIGALRM:   "ALRM",
+	syscall.SIGTERM:   "TERM",
+	syscall.SIGCHLD:   "CHLD",
+	syscall.SIGCONT:   "CONT",
+	syscall.SIGSTOP:   "STOP",
+	syscall.SIGTSTP:   "TSTP",
+	syscall.SIGTTIN:   "TTIN",
+	syscall.SIGTTOU:   "TTOU",
+	syscall.SIGURG:    "URG",
+	syscall.SIGXCPU:   "XCPU",
+	syscall.SIGXFSZ:   "XFSZ",
+	syscall.SIGVTALRM: "VTALRM",
+	syscall.SIGPROF:   "PROF",
+	syscall.SIGWINCH:  "WINCH",
+	syscall.SIGIO:     "IO",
+	syscall.SIGSYS:    "SYS",
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", int(sig))
+}
+
+// signal sends a signal to the command's process group.
+func (cmd *command) signal(name string) error {
+	sig, ok := signalsByName[name]
+	if !ok {
+		return fmt.Errorf("unknown signal %q", name)
+	}
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	if cmd.pid == 0 || cmd.exited {
+		return fmt.Errorf("command not running")
+	}
+	// The pty made the command a session leader, so its process group
+	// id is its pid.
+	return syscall.Kill(-cmd.pid, sig)
+}
+
+// send sends output for this command to the client.
+// Called with cmd.mu held.
 func (cmd *command) send(msg proto.Msg) error {
-	return cmd.conn.writeMsg(&proto.CellOutput{
+	return cmd.session.send(&proto.CellOutput{
 		Cell:   cmd.req.Cell,
-		Output: proto.Output{msg},
+		Output: proto.Output{Alt: msg},
 	})
 }
 
+// sendError records and sends an error that prevented the command from running.
+// Called with cmd.mu held.
 func (cmd *command) sendError(msg string) error {
-	return cmd.send(&proto.CmdError{msg})
+	cmd.err = msg
+	return cmd.send(&proto.CmdError{Error: msg})
+}
+
+// state snapshots the command for a client attaching to the session.
+// Called with cmd.mu held.
+func (cmd *command) state() proto.CellState {
+	// Include only the screen, so attaching is quick however much output
+	// there is; the client fetches earlier rows as it needs them.
+	dirty := vt100.TermDirty{Cursor: true, Lines: map[int]bool{}}
+	for row := cmd.term.Top; row < len(cmd.term.Lines); row++ {
+		dirty.Lines[row] = true
+	}
+	return proto.CellState{
+		Cell:    cmd.req.Cell,
+		Cwd:     cmd.req.Cwd,
+		Argv:    cmd.req.Argv,
+		Running: !cmd.exited,
+		Error:   cmd.err,
+		Exit:    cmd.exit,
+		Term:    *cmd.termUpdate(&dirty),
+	}
+}
+
+// termUpdate renders the command's terminal, along with the state of
+// its scrollback history.
+// Called with cmd.mu held.
+func (cmd *command) termUpdate(dirty *vt100.TermDirty) *proto.TermUpdate {
+	update := termUpdate(cmd.term, dirty)
+	update.History = cmd.historyLines()
+	return update
+}
+
+// termUpdate renders the dirty parts of a terminal into an update message.
+func termUpdate(term *vt100.Terminal, dirty *vt100.TermDirty) *proto.TermUpdate {
+	allDirty := dirty.Lines[-1]
+	// The client always applies the cursor, so send it even if it hasn't
+	// changed.  This also lets updates be merged without tracking which
+	// ones moved the cursor.
+	update := &proto.TermUpdate{
+		Cursor: proto.Cursor{
+			Row:    term.Row,
+			Col:    term.Col,
+			Hidden: term.HideCursor,
+		},
+	}
+	if allDirty {
+		update.Rows = make([]proto.RowSpans, 0, len(term.Lines))
+		for row, l := range term.Lines {
+			update.Rows = append(update.Rows, rowSpans(row, l))
+		}
+	} else {
+		for _, row := range dirtyRows(dirty, len(term.Lines)) {
+			update.Rows = append(update.Rows, rowSpans(row, term.Lines[row]))
+		}
+	}
+	update.RowCount = len(term.Lines)
+	update.AltScreen = term.AltScreen()
+	update.Mouse = uint8(term.Mouse)
+	update.AppCursorKeys = term.AppCursorKeys
+	update.AppKeypad = term.AppKeypad
+	update.BracketedPaste = term.BracketedPaste
+	return update
+}
+
+// pausingReader reads a command's output, first waiting for the client
+// to catch up if it has fallen behind.  Leaving output unread in the pty
+// in turn blocks the command once the pty's buffer fills.
+type pausingReader struct {
+	cmd *command
+	r   io.Reader
+}
+
+func (r *pausingReader) Read(buf []byte) (int, error) {
+	r.cmd.session.waitBacklog(r.cmd.req.Cell)
+	return r.r.Read(buf)
+}
+
+// dirtyRows returns the dirty rows of a terminal with the given number of
+// lines, in order.
+func dirtyRows(dirty *vt100.TermDirty, lines int) []int {
+	rows := make([]int, 0, len(dirty.Lines))
+	for row := range dirty.Lines {
+		// Rows may have been dirtied and then scrolled away.
+		if row >= 0 && row < lines {
+			rows = append(rows, row)
+		}
+	}
+	sort.Ints(rows)
+	return rows
+}
+
+// rowSpans renders one line of a terminal into spans of like attributes.
+func rowSpans(row int, l []vt100.Cell) proto.RowSpans {
+	rs := proto.RowSpans{Row: row}
+	var text strings.Builder
+	span := proto.Span{}
+	var attr vt100.Attr
+	for _, cell := range l {
+		if cell.Attr != attr {
+			span.Text = text.String()
+			rs.Spans = append(rs.Spans, span)
+			text.Reset()
+			attr = cell.Attr
+			span = proto.Span{
+				Attr: attr.Flags(),
+				Fg:   attr.Color(),
+				Bg:   attr.BackColor(),
+			}
+		}
+		cell.WriteText(&text)
+	}
+	if text.Len() > 0 {
+		span.Text = text.String()
+		rs.Spans = append(rs.Spans, span)
+	}
+	return rs
 }
 
 func termLoop(tr *vt100.TermReader, r io.Reader) error {
@@ -115,91 +530,56 @@ func termLoop(tr *vt100.TermReader, r io.Reader) error {
 // updates as it progresses.  It may return errors if the subprocess failed
 // to run for whatever reason (e.g. no such path), and otherwise returns
 // the subprocess exit code.
-func (cmd *command) run() (int, error) {
+func (cmd *command) run() (proto.Exit, error) {
 	if cmd.cmd.Path == "cd" {
 		if len(cmd.cmd.Args) != 2 {
-			return 0, fmt.Errorf("bad arguments to cd")
+			return proto.Exit{}, fmt.Errorf("bad arguments to cd")
 		}
 		dir := cmd.cmd.Args[1]
 		st, err := os.Stat(dir)
 		if err != nil {
-			return 0, err
+			return proto.Exit{}, err
 		}
 		if !st.IsDir() {
-			return 0, fmt.Errorf("%s: not a directory", dir)
+			return proto.Exit{}, fmt.Errorf("%s: not a directory", dir)
 		}
-		return 0, nil
+		return proto.Exit{}, nil
 	}
 
 	if filepath.Base(cmd.cmd.Path) == cmd.cmd.Path {
-		// TODO: should use shell env $PATH.
-		if p, err := exec.LookPath(cmd.cmd.Path); err != nil {
-			return 0, err
+		path := envLookup(cmd.cmd.Env, "PATH")
+		if p, err := globalPathCache.lookPath(cmd.cmd.Path, path); err != nil {
+			return proto.Exit{}, err
 		} else {
 			cmd.cmd.Path = p
 		}
 	}
 
 	size := pty.Winsize{
-		Rows: 24,
-		Cols: 80,
+		Rows: uint16(cmd.term.Height),
+		Cols: uint16(cmd.term.Width),
 	}
+	start := time.Now()
 	f, err := pty.StartWithSize(cmd.cmd, &size)
 	if err != nil {
-		return 0, err
+		return proto.Exit{}, err
 	}
+	defer f.Close()
 
-	cmd.stdin = make(chan []byte)
-	go func() {
-		for input := range cmd.stdin {
-			f.Write(input)
-		}
-	}()
+	go cmd.input.copyTo(f)
 
-	var mu sync.Mutex // protects term, drawPending, and done
-	wake := sync.NewCond(&mu)
-	term := vt100.NewTerminal()
+	mu := &cmd.mu // protects cmd.term, drawPending, and done
+	wake := sync.NewCond(mu)
+	term := cmd.term
 	drawPending := false
 	var done error
 
 	var tr *vt100.TermReader
 	renderFromDirty := func() {
 		// Called with mu held.
-		allDirty := tr.Dirty.Lines[-1]
-		update := &proto.TermUpdate{}
-		if tr.Dirty.Cursor {
-			update.Cursor = proto.Cursor{
-				Row:    term.Row,
-				Col:    term.Col,
-				Hidden: term.HideCursor,
-			}
-		}
-		for row, l := range term.Lines {
-			// TODO: iterate tr.Dirty, not all lines.
-			if !(allDirty || tr.Dirty.Lines[row]) {
-				continue
-			}
-			rowSpans := proto.RowSpans{
-				Row: row,
-			}
-			span := proto.Span{}
-			var attr vt100.Attr
-			for _, cell := range l {
-				if cell.Attr != attr {
-					attr = cell.Attr
-					rowSpans.Spans = append(rowSpans.Spans, span)
-					span = proto.Span{Attr: int(attr)}
-				}
-				// TODO: super inefficient.
-				span.Text += fmt.Sprintf("%c", cell.Ch)
-			}
-			if len(span.Text) > 0 {
-				rowSpans.Spans = append(rowSpans.Spans, span)
-			}
-			update.Rows = append(update.Rows, rowSpans)
-		}
-		update.RowCount = len(term.Lines)
-
+		trimmed := cmd.trimScrollback(&tr.Dirty)
+		update := cmd.termUpdate(&tr.Dirty)
+		update.Trimmed = trimmed
 		err := cmd.send(update)
 		if err != nil {
 			done = err
@@ -217,9 +597,14 @@ func (cmd *command) run() (int, error) {
 		}
 		mu.Unlock()
 	})
+	mu.Lock()
+	cmd.tr = tr
+	cmd.pty = f
+	cmd.pid = cmd.cmd.Process.Pid
+	mu.Unlock()
 
 	go func() {
-		err := termLoop(tr, f)
+		err := termLoop(tr, &pausingReader{cmd: cmd, r: f})
 		mu.Lock()
 		done = err
 		wake.Signal()
@@ -253,48 +638,102 @@ func (cmd *command) run() (int, error) {
 	}
 
 	mu.Lock()
-	globalLastTermForCmd = term
+	cmd.tr = nil
+	cmd.pty = nil
+	mu.Unlock()
 
 	// done is the error reported by the terminal.
 	// We expect EOF in normal execution.
 	if done != io.EOF {
-		return 0, err
+		return proto.Exit{}, done
 	}
 
-	// Reap the subprocess and report the exit code.
+	// Reap the subprocess and report how it exited.
 	if err := cmd.cmd.Wait(); err != nil {
-		if eerr, ok := err.(*exec.ExitError); ok {
-			serr := eerr.Sys().(syscall.WaitStatus)
-			return serr.ExitStatus(), nil
-		} else {
-			return 0, err
+		if _, ok := err.(*exec.ExitError); !ok {
+			return proto.Exit{}, err
 		}
 	}
-	return 0, nil
+	return exitStatus(cmd.cmd.ProcessState, time.Since(start)), nil
+}
+
+// exitStatus describes how a process exited, for the client.
+func exitStatus(state *os.ProcessState, wall time.Duration) proto.Exit {
+	exit := proto.Exit{WallMs: int(wall / time.Millisecond)}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok {
+		switch {
+		case ws.Exited():
+			exit.ExitCode = ws.ExitStatus()
+		case ws.Signaled():
+			// Report the code a shell would, 128 + the signal number.
+			exit.ExitCode = 128 + int(ws.Signal())
+			exit.Signal = signalName(ws.Signal())
+			exit.CoreDumped = ws.CoreDump()
+		}
+	} else {
+		exit.ExitCode = state.ExitCode()
+	}
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		exit.UserMs = int(time.Duration(ru.Utime.Nano()) / time.Millisecond)
+		exit.SysMs = int(time.Duration(ru.Stime.Nano()) / time.Millisecond)
+		exit.MaxRssKb = int(ru.Maxrss)
+		if runtime.GOOS == "darwin" {
+			// Darwin reports bytes rather than kilobytes.
+			exit.MaxRssKb /= 1024
+		}
+	}
+	return exit
+}
+
+// shellNotFound returns an error suggesting similar commands if the
+// command was a shell script whose command wasn't found in $PATH, which
+// the shell reports with exit code 127, or nil if there are none.
+func (cmd *command) shellNotFound() error {
+	name := shellCommandName(cmd.req.Argv)
+	if name == "" {
+		return nil
+	}
+	_, err := globalPathCache.lookPath(name, envLookup(cmd.cmd.Env, "PATH"))
+	if err, ok := err.(*notFoundError); ok && len(err.suggestions) > 0 {
+		return err
+	}
+	// Without suggestions there's nothing to add to the shell's own
+	// message, and a command that was found exited 127 itself.
+	return nil
 }
 
 // runHandlingErrors calls run() and forwards any subprocess errors
 // on to the client.
 func (cmd *command) runHandlingErrors() {
-	exitCode, err := cmd.run()
+	exit, err := cmd.run()
+	cmd.input.close(errInputClosed)
+	var notFound error
+	if err == nil && exit.ExitCode == 127 {
+		notFound = cmd.shellNotFound()
+	}
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
 	if err != nil {
 		cmd.sendError(err.Error())
-		exitCode = 1
-	}
-	if exitCode < 0 {
-		exitCode = 1 // TODO: negative exit codes from signals
-	}
-	cmd.send(&proto.Exit{exitCode})
+		exit.ExitCode = 1
+	} else if notFound != nil {
+		// Keep the shell's exit code.
+		cmd.sendError(notFound.Error())
+	}
+	cmd.exited = true
+	cmd.exit = exit
+	if cmd.history != nil {
+		// No more lines will be stored.
+		cmd.history.finish()
+	}
+	cmd.send(&exit)
+	cmd.session.finish(cmd)
 }
 
-var localCommands = map[string]func(w io.Writer) error{
-	"that": func(w io.Writer) error {
-		if globalLastTermForCmd == nil {
-			return nil
-		}
-		_, err := io.WriteString(w, globalLastTermForCmd.ToString())
-		return err
-	},
+// localCommands maps the names of `smash` subcommands, which run within
+// the server, to functions that run them and return an exit status.
+var localCommands = map[string]func(c *localCmd) int{
+	"that": localThat,
 }
 
 func getEnv() map[string]string {
@@ -312,7 +751,7 @@ func getEnv() map[string]string {
 func mapPairs(m map[string]string) []proto.Pair {
 	pairs := []proto.Pair{}
 	for k, v := range m {
-		pairs = append(pairs, proto.Pair{k, v})
+		pairs = append(pairs, proto.Pair{Key: k, Val: v})
 	}
 	return pairs
 }
@@ -322,8 +761,10 @@ func serveWS(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	conn := &conn{
-		ws: wsConn,
+	conn := newConn(wsConn)
+	defer conn.close()
+	if err := conn.handshake(); err != nil {
+		return err
 	}
 
 	smashPath, err := os.Readlink("/proc/self/exe")
@@ -335,18 +776,19 @@ func serveWS(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	sess := globalSessions.get(r.URL.Query().Get("session"))
 	env := getEnv()
 	env["SMASH"] = smashPath
-	env["SMASH_SOCK"] = globalSockPathForEnv
+	env["SMASH_SOCK"] = sess.sockPath
 	hello := &proto.Hello{
 		Alias: mapPairs(aliases),
 		Env:   mapPairs(env),
 	}
-	if err = conn.writeMsg(hello); err != nil {
+	if err = sess.attach(conn, hello); err != nil {
 		return err
 	}
+	defer sess.detach(conn)
 
-	commands := map[int]*command{}
 	for {
 		_, buf, err := conn.ws.ReadMessage()
 		if err != nil {
@@ -359,18 +801,90 @@ func serveWS(w http.ResponseWriter, r *http.Request) error {
 
 		switch msg := msg.Alt.(type) {
 		case *proto.RunRequest:
-			cmd := newCmd(conn, msg)
-			commands[int(msg.Cell)] = cmd
+			cmd := newCmd(sess, msg)
+			sess.addCommand(cmd)
 			go cmd.runHandlingErrors()
 		case *proto.KeyEvent:
-			cmd := commands[int(msg.Cell)]
+			cmd := sess.command(msg.Cell)
 			if cmd == nil {
 				log.Println("got key msg for unknown command", msg.Cell)
 				continue
 			}
-			// TODO: what if cmd failed?
-			// TODO: what if pipe is blocked?
-			cmd.stdin <- []byte(msg.Keys)
+			if err := cmd.input.write([]byte(msg.Keys)); err != nil {
+				cmd.inputError(err)
+			}
+		case *proto.Resize:
+			cmd := sess.command(msg.Cell)
+			if cmd == nil {
+				log.Println("got resize msg for unknown command", msg.Cell)
+				continue
+			}
+			if err := cmd.resize(msg.Size); err != nil {
+				log.Println(err)
+			}
+		case *proto.MouseEvent:
+			cmd := sess.command(msg.Cell)
+			if cmd == nil {
+				log.Println("got mouse msg for unknown command", msg.Cell)
+				continue
+			}
+			if err := cmd.mouse(msg); err != nil {
+				cmd.inputError(err)
+			}
+		case *proto.Signal:
+			cmd := sess.command(msg.Cell)
+			if cmd == nil {
+				log.Println("got signal msg for unknown command", msg.Cell)
+				continue
+			}
+			result := &proto.SignalResult{Signal: msg.Signal}
+			if err := cmd.signal(msg.Signal); err != nil {
+				result.Error = fmt.Sprintf("SIG%s: %s", msg.Signal, err)
+			}
+			cmd.mu.Lock()
+			cmd.send(result)
+			cmd.mu.Unlock()
+		case *proto.Paste:
+			cmd := sess.command(msg.Cell)
+			if cmd == nil {
+				log.Println("got paste msg for unknown command", msg.Cell)
+				continue
+			}
+			if err := cmd.paste(msg.Text); err != nil {
+				cmd.inputError(err)
+			}
+		case *proto.ScrollbackRequest:
+			cmd := sess.command(msg.Cell)
+			if cmd == nil {
+				log.Println("got scrollback msg for unknown command", msg.Cell)
+				continue
+			}
+			go func() {
+				resp := &proto.ScrollbackResponse{Cell: msg.Cell}
+				rows, err := cmd.readScrollback(msg.Start, msg.End)
+				if err != nil {
+					resp.Error = err.Error()
+				}
+				resp.Rows = rows
+				if err := conn.writeMsg(resp); err != nil {
+					log.Println(err)
+				}
+			}()
+		case *proto.RowsRequest:
+			cmd := sess.command(msg.Cell)
+			if cmd == nil {
+				log.Println("got rows msg for unknown command", msg.Cell)
+				continue
+			}
+			if err := cmd.sendRows(msg.Start, msg.End); err != nil {
+				log.Println(err)
+			}
+		case *proto.SearchRequest:
+			go func() {
+				if err := conn.writeMsg(sess.search(msg)); err != nil {
+					log.Println(err)
+				}
+			}()
 		case *proto.CompleteRequest:
 			if msg.Cwd == "" {
 				panic("incomplete complete request")
@@ -399,17 +913,6 @@ func serveWS(w http.ResponseWriter, r *http.Request) error {
 }
 
 func serve() error {
-	sockPath, localSock, err := setupLocalCommandSock()
-	if err != nil {
-		return err
-	}
-	go func() {
-		if err := readLocalCommands(localSock); err != nil {
-			fmt.Fprintf(os.Stderr, "local sock: %s\n", err)
-		}
-	}()
-	globalSockPathForEnv = sockPath
-
 	b, err := bash.StartBash()
 	if err != nil {
 		return err
@@ -427,26 +930,6 @@ func serve() error {
 	return http.ListenAndServe(addr, nil)
 }
 
-func localCommand(cmd string) error {
-	sockPath := os.Getenv("SMASH_SOCK")
-	if sockPath == "" {
-		return fmt.Errorf("no $SMASH_SOCK; are you running under smash?")
-	}
-
-	conn, err := net.Dial("unix", sockPath)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	if _, err = fmt.Fprintf(conn, "%s\n", cmd); err != nil {
-		return err
-	}
-	if _, err = io.Copy(os.Stdout, conn); err != nil {
-		return err
-	}
-	return nil
-}
-
 func main() {
 	var cmd = "serve"
 	if len(os.Args) > 1 {
@@ -455,7 +938,11 @@ func main() {
 
 	var err error
 	if _, isLocal := localCommands[cmd]; isLocal {
-		err = localCommand(cmd)
+		var code int
+		code, err = runLocal(cmd, os.Args[2:])
+		if err == nil {
+			os.Exit(code)
+		}
 	} else {
 		switch cmd {
 		case "serve":
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evmar/smash/proto"
+	"github.com/evmar/smash/vt100"
+	"github.com/kr/pty"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	// Delete the scrollback files of tests' commands.
+	if scrollbackDir.path != "" {
+		os.RemoveAll(scrollbackDir.path)
+	}
+	os.Exit(code)
+}
+
+// newBenchTerminal returns a terminal that has printed lines lines of
+// colored output, as from a long-running build.
+func newBenchTerminal(b *testing.B, lines int) (*vt100.Terminal, *vt100.TermReader) {
+	term := vt100.NewTerminal()
+	tr := vt100.NewTermReader(func(f func(t *vt100.Terminal)) {
+		f(term)
+	})
+	var buf strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&buf, "\x1b[32mok\x1b[m   package/number/%d\t%dms\r\n", i, i%1000)
+	}
+	feed(b, tr, buf.String())
+	return term, tr
+}
+
+func feed(t testing.TB, tr *vt100.TermReader, text string) {
+	r := bufio.NewReader(strings.NewReader(text))
+	for {
+		if err := tr.Read(r); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+}
+
+// BenchmarkTermUpdateScrollback measures an update for one new line of
+// output at the bottom of a long scrollback.
+func BenchmarkTermUpdateScrollback(b *testing.B) {
+	term, tr := newBenchTerminal(b, 10000)
+	tr.Dirty.Reset()
+	feed(b, tr, "one more line\r\n")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		termUpdate(term, &tr.Dirty)
+	}
+}
+
+// BenchmarkTermUpdateAll measures rendering every line, as when a client
+// attaches.
+func BenchmarkTermUpdateAll(b *testing.B) {
+	term, _ := newBenchTerminal(b, 10000)
+	dirty := vt100.TermDirty{Cursor: true, Lines: map[int]bool{-1: true}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		termUpdate(term, &dirty)
+	}
+}
+
+func TestResizeClamped(t *testing.T) {
+	s := newTestSession(t)
+	huge := proto.TermSize{Rows: 1 << 40, Cols: 70000}
+	cmd := newCmd(s, &proto.RunRequest{Cell: 0, Argv: []string{"test"}, Size: huge})
+	assert.Equal(t, maxTermSize, cmd.term.Width)
+	assert.Equal(t, maxTermSize, cmd.term.Height)
+
+	// Stand in for a running command with a pty and its reader.
+	f, tty, err := pty.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer tty.Close()
+	cmd.pty = f
+	cmd.tr = vt100.NewTermReader(func(fn func(t *vt100.Terminal)) {
+		fn(cmd.term)
+	})
+
+	assert.NoError(t, cmd.resize(proto.TermSize{Rows: 30, Cols: 100}))
+	assert.NoError(t, cmd.resize(huge))
+	assert.Equal(t, maxTermSize, cmd.term.Width)
+	assert.Equal(t, maxTermSize, cmd.term.Height)
+	rows, cols, err := pty.Getsize(f)
+	assert.NoError(t, err)
+	assert.Equal(t, maxTermSize, rows)
+	assert.Equal(t, maxTermSize, cols)
+	// The alternate screen allocates a line per row.
+	feed(t, cmd.tr, "\x1b[?1049hok")
+	assert.Equal(t, maxTermSize, len(cmd.term.Lines))
+
+	assert.Error(t, cmd.resize(proto.TermSize{Rows: -1, Cols: 80}))
+}
+
+func TestShellNotFound(t *testing.T) {
+	dir := testPathDir(t, map[string]os.FileMode{"git": 0755})
+	defer os.RemoveAll(dir)
+	s := newTestSession(t)
+	run := func(script string) *command {
+		cmd := newCmd(s, &proto.RunRequest{
+			Argv:   []string{"/bin/sh", "-c", script},
+			SetEnv: []proto.Pair{{Key: "PATH", Val: dir}},
+		})
+		s.addCommand(cmd)
+		cmd.runHandlingErrors()
+		return cmd
+	}
+
+	cmd := run("gti status")
+	assert.Equal(t, 127, cmd.exit.ExitCode)
+	assert.Equal(t, "gti: command not found; did you mean git?", cmd.err)
+
+	// Nothing similar to suggest.
+	cmd = run("zzz")
+	assert.Equal(t, 127, cmd.exit.ExitCode)
+	assert.Equal(t, "", cmd.err)
+
+	// The command itself exited 127.
+	if err := ioutil.WriteFile(filepath.Join(dir, "git"), []byte("exit 127\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd = run("git status")
+	assert.Equal(t, 127, cmd.exit.ExitCode)
+	assert.Equal(t, "", cmd.err)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// localThat implements `smash that`, which prints the output of an
+// earlier cell so that pipelines can consume it.
+func localThat(c *localCmd) int {
+	flags := c.flags("that")
+	cell := flags.Int("cell", -1, "print the cell with this `id`, whether finished or not")
+	grep := flags.String("grep", "", "only count cells with a line of output matching `pattern`")
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: smash that [-N] [--cell id] [--grep pattern]\n\n")
+		fmt.Fprintf(c.stderr, "Prints the output of the Nth most recently finished cell, by default the last.\n\n")
+		flags.PrintDefaults()
+	}
+
+	// -N can't be a flag, so take it from the front of the arguments.
+	n := 1
+	args := c.args
+	if len(args) > 0 {
+		if i, err := strconv.Atoi(args[0]); err == nil && i < 0 {
+			n = -i
+			args = args[1:]
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 || (*cell >= 0 && (n != 1 || *grep != "")) {
+		flags.Usage()
+		return 2
+	}
+
+	var cmd *command
+	var err error
+	if *cell >= 0 {
+		if cmd = c.session.command(*cell); cmd == nil {
+			err = fmt.Errorf("no cell %d", *cell)
+		}
+	} else {
+		cmd, err = c.session.that(n, *grep)
+	}
+	if err == nil {
+		err = cmd.writeOutput(c.stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "smash that: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// that finds the nth most recently finished command, counting only those
+// with output matching grep if it is set.
+func (s *session) that(n int, grep string) (*command, error) {
+	var re *regexp.Regexp
+	if grep != "" {
+		var err error
+		if re, err = regexp.Compile(grep); err != nil {
+			return nil, err
+		}
+	}
+	finished := s.finishedCommands()
+	for i := len(finished) - 1; i >= 0; i-- {
+		cmd := finished[i]
+		if re != nil {
+			matched, err := cmd.matches(re)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		if n--; n == 0 {
+			return cmd, nil
+		}
+	}
+	if re != nil {
+		return nil, fmt.Errorf("not enough finished cells matching %q", grep)
+	}
+	return nil, fmt.Errorf("not enough finished cells")
+}
+
+// eachLine calls fn with the text of each line of the command's output,
+// including lines trimmed into its scrollback history, until fn returns
+// false.
+func (cmd *command) eachLine(fn func(line string) bool) error {
+	// Copy the terminal's text under the lock, but read the history,
+	// which is on disk, without it, so as not to stall the command's
+	// output.
+	var history *scrollbackReader
+	cmd.mu.Lock()
+	if cmd.history != nil {
+		history = cmd.history.reader()
+	}
+	lines := make([]string, len(cmd.term.Lines))
+	for i, l := range cmd.term.Lines {
+		lines[i], _ = lineText(l)
+	}
+	cmd.mu.Unlock()
+
+	if history != nil {
+		for start := 0; start < history.lines; start += maxScrollbackPage {
+			end := start + maxScrollbackPage
+			if end > history.lines {
+				end = history.lines
+			}
+			rows, err := history.read(start, end)
+			if err != nil {
+				return err
+			}
+			for _, row := range rows {
+				var text strings.Builder
+				for _, span := range row.Spans {
+					text.WriteString(span.Text)
+				}
+				if !fn(text.String()) {
+					return nil
+				}
+			}
+		}
+	}
+	for _, line := range lines {
+		if !fn(line) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// matches reports whether any line of the command's output matches re.
+func (cmd *command) matches(re *regexp.Regexp) (bool, error) {
+	found := false
+	err := cmd.eachLine(func(line string) bool {
+		found = re.MatchString(line)
+		return !found
+	})
+	return found, err
+}
+
+// writeOutput writes the text of the command's output to w.
+func (cmd *command) writeOutput(w io.Writer) error {
+	// Collect the text first, so that a slow reader doesn't hold up a
+	// running command's output.
+	buf := &bytes.Buffer{}
+	first := true
+	err := cmd.eachLine(func(line string) bool {
+		if !first {
+			buf.WriteByte('\n')
+		}
+		first = false
+		buf.WriteString(line)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/evmar/smash/vt100"
+	"github.com/stretchr/testify/assert"
+)
+
+// runThat runs `smash that args...` in s, returning its exit status and
+// output.
+func runThat(s *session, args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := localThat(&localCmd{
+		session: s,
+		args:    args,
+		stdin:   strings.NewReader(""),
+		stdout:  stdout,
+		stderr:  stderr,
+	})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestThat(t *testing.T) {
+	s := newTestSession(t, "one\r\n", "two\r\n", "three\r\n", "running\r\n")
+	// Finish out of cell order; "that" goes by when cells finished.
+	for _, cell := range []int{1, 0, 2} {
+		s.finish(s.command(cell))
+	}
+
+	for _, test := range []struct {
+		args []string
+		out  string
+	}{
+		{nil, "three\n"},
+		{[]string{"-1"}, "three\n"},
+		{[]string{"-2"}, "one\n"},
+		{[]string{"-3"}, "two\n"},
+		{[]string{"--cell", "3"}, "running\n"},
+		{[]string{"--grep", "o"}, "one\n"},
+		{[]string{"-2", "--grep", "o"}, "two\n"},
+		{[]string{"--grep", "^t.o$"}, "two\n"},
+	} {
+		code, out, stderr := runThat(s, test.args...)
+		assert.Equal(t, 0, code, "%q: %s", test.args, stderr)
+		assert.Equal(t, test.out, out, "%q", test.args)
+	}
+
+	code, _, stderr := runThat(s, "-4")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "smash that: not enough finished cells\n", stderr)
+	code, _, stderr = runThat(s, "--grep", "nothing")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "not enough finished cells matching")
+	code, _, stderr = runThat(s, "--cell", "9")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "smash that: no cell 9\n", stderr)
+
+	// Usage errors.
+	for _, args := range [][]string{{"extra"}, {"--cell", "1", "-2"}, {"--bogus"}} {
+		code, _, stderr = runThat(s, args...)
+		assert.Equal(t, 2, code, "%q", args)
+		assert.Contains(t, stderr, "usage: smash that", "%q", args)
+	}
+}
+
+func TestThatHistory(t *testing.T) {
+	var output strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&output, "line %d\r\n", i)
+	}
+	s := newTestSession(t, output.String())
+	cmd := s.command(0)
+	// Move the lines above the screen into the history store.
+	dirty := vt100.TermDirty{Lines: map[int]bool{}}
+	if err := cmd.storeHistory(cmd.term.TrimScrollback(&dirty, 0)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 50-cmd.term.Height+1, cmd.history.lines)
+	s.finish(cmd)
+
+	_, out, _ := runThat(s)
+	assert.Equal(t, strings.Replace(output.String(), "\r", "", -1), out)
+	_, out, _ = runThat(s, "--grep", "^line 3$")
+	assert.Equal(t, strings.Replace(output.String(), "\r", "", -1), out)
+}
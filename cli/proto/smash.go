@@ -2,10 +2,14 @@ package proto
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 )
 
+// SchemaHash identifies the schema this code was generated from.
+const SchemaHash = "95a117bbe4e82848"
+
 type Msg interface {
 	Write(w io.Writer) error
 	Read(r *bufio.Reader) error
@@ -23,29 +27,85 @@ func ReadUint8(r *bufio.Reader) (byte, error) {
 	return r.ReadByte()
 }
 
-func ReadInt(r *bufio.Reader) (int, error) {
-	shift := 0
-	var val int
-	for {
+// maxVarintLen is the longest a varint can be, enough for 64 bits.
+const maxVarintLen = 10
+
+// maxPrealloc bounds how much ReadBytes allocates up front.  Past that,
+// the buffer grows as the data arrives, so that a bogus length can't make
+// us allocate more memory than the input holds.
+const maxPrealloc = 64 << 10
+
+func readUvarint(r *bufio.Reader) (uint64, error) {
+	var val uint64
+	for i := 0; i < maxVarintLen; i++ {
 		b, err := r.ReadByte()
 		if err != nil {
 			return 0, err
 		}
-		val |= int(b&0b0111_1111) << shift
+		if i == maxVarintLen-1 && b > 1 {
+			break
+		}
+		val |= uint64(b&0b0111_1111) << (7 * i)
 		if (b & 0b1000_0000) == 0 {
 			return val, nil
 		}
-		shift = shift + 7
 	}
+	return 0, fmt.Errorf("varint overflows 64 bits")
 }
 
-func ReadString(r *bufio.Reader) (string, error) {
+func ReadInt(r *bufio.Reader) (int, error) {
+	val, err := readUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	n := int(val)
+	if n < 0 || uint64(n) != val {
+		return 0, fmt.Errorf("int %d out of range", val)
+	}
+	return n, nil
+}
+
+// ReadSint reads a signed int, which is zigzag-encoded so that small
+// negative numbers stay short: 0, -1, 1, -2, ... are sent as 0, 1, 2, 3, ...
+func ReadSint(r *bufio.Reader) (int, error) {
+	val, err := readUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	signed := int64(val>>1) ^ -int64(val&1)
+	n := int(signed)
+	if int64(n) != signed {
+		return 0, fmt.Errorf("sint %d out of range", signed)
+	}
+	return n, nil
+}
+
+func ReadBytes(r *bufio.Reader) ([]byte, error) {
 	n, err := ReadInt(r)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if n <= maxPrealloc {
+		buf := make([]byte, n)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(maxPrealloc)
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
 	}
-	buf := make([]byte, n)
-	_, err = io.ReadFull(r, buf)
+	return buf.Bytes(), nil
+}
+
+func ReadString(r *bufio.Reader) (string, error) {
+	buf, err := ReadBytes(r)
 	if err != nil {
 		return "", err
 	}
@@ -64,10 +124,7 @@ func WriteUint8(w io.Writer, val byte) error {
 	_, err := w.Write(buf[:])
 	return err
 }
-func WriteInt(w io.Writer, val int) error {
-	if val < 0 {
-		panic("negative")
-	}
+func writeUvarint(w io.Writer, val uint64) error {
 	for {
 		b := byte(val & 0b0111_1111)
 		val = val >> 7
@@ -80,19 +137,38 @@ func WriteInt(w io.Writer, val int) error {
 		}
 	}
 }
-func WriteString(w io.Writer, str string) error {
-	if len(str) >= 1<<16 {
-		panic("overlong")
+func WriteInt(w io.Writer, val int) error {
+	if val < 0 {
+		return fmt.Errorf("negative int %d; use sint for signed values", val)
+	}
+	return writeUvarint(w, uint64(val))
+}
+func WriteSint(w io.Writer, val int) error {
+	if val < 0 {
+		return writeUvarint(w, uint64(^val)<<1|1)
 	}
+	return writeUvarint(w, uint64(val)<<1)
+}
+func WriteBytes(w io.Writer, buf []byte) error {
+	if err := WriteInt(w, len(buf)); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
+	return err
+}
+func WriteString(w io.Writer, str string) error {
 	if err := WriteInt(w, len(str)); err != nil {
 		return err
 	}
-	_, err := w.Write([]byte(str))
+	_, err := io.WriteString(w, str)
 	return err
 }
 
+type ClientHello struct {
+	Schema string
+}
 type ClientMessage struct {
-	// CompleteRequest, RunRequest, KeyEvent
+	// CompleteRequest, RunRequest, KeyEvent, Resize, MouseEvent, Paste, Signal, ScrollbackRequest, RowsRequest, SearchRequest
 	Alt Msg
 }
 type CompleteRequest struct {
@@ -107,21 +183,92 @@ type CompleteResponse struct {
 	Pos         int
 	Completions []string
 }
+type TermSize struct {
+	Rows int
+	Cols int
+}
 type RunRequest struct {
-	Cell int
-	Cwd  string
-	Argv []string
+	Cell           int
+	Cwd            string
+	Argv           []string
+	Size           TermSize
+	SetEnv         []Pair
+	UnsetEnv       []string
+	Scrollback     int
+	DropScrollback bool
 }
 type KeyEvent struct {
 	Cell int
 	Keys string
 }
+type Paste struct {
+	Cell int
+	Text string
+}
+type Signal struct {
+	Cell   int
+	Signal string
+}
+type ScrollbackRequest struct {
+	Cell  int
+	Start int
+	End   int
+}
+type ScrollbackResponse struct {
+	Cell  int
+	Error string
+	Rows  []RowSpans
+}
+type RowsRequest struct {
+	Cell  int
+	Start int
+	End   int
+}
+type SearchRequest struct {
+	Id         int
+	Query      string
+	Regex      bool
+	IgnoreCase bool
+	Cells      []int
+}
+type SearchMatch struct {
+	Cell    int
+	Row     int
+	History bool
+	Start   int
+	End     int
+	Before  string
+	Match   string
+	After   string
+}
+type SearchResponse struct {
+	Id        int
+	Error     string
+	Matches   []SearchMatch
+	Truncated bool
+}
+type Resize struct {
+	Cell int
+	Size TermSize
+}
+type MouseEvent struct {
+	Cell   int
+	Action uint8
+	Button uint8
+	Row    int
+	Col    int
+	Shift  bool
+	Alt    bool
+	Ctrl   bool
+}
 type RowSpans struct {
 	Row   int
 	Spans []Span
 }
 type Span struct {
 	Attr int
+	Fg   int
+	Bg   int
 	Text string
 }
 type Cursor struct {
@@ -130,26 +277,60 @@ type Cursor struct {
 	Hidden bool
 }
 type TermUpdate struct {
-	Rows     []RowSpans
-	Cursor   Cursor
-	RowCount int
+	Rows           []RowSpans
+	Cursor         Cursor
+	RowCount       int
+	Trimmed        int
+	History        int
+	AltScreen      bool
+	Mouse          uint8
+	AppCursorKeys  bool
+	AppKeypad      bool
+	BracketedPaste bool
 }
 type Pair struct {
 	Key string
 	Val string
 }
-type Hello struct {
-	Alias []Pair
-	Env   []Pair
-}
 type CmdError struct {
 	Error string
 }
 type Exit struct {
-	ExitCode int
+	ExitCode   int
+	Signal     string
+	CoreDumped bool
+	WallMs     int
+	UserMs     int
+	SysMs      int
+	MaxRssKb   int
+}
+type InputError struct {
+	Error string
+}
+type SignalResult struct {
+	Signal string
+	Error  string
+}
+type CellState struct {
+	Cell    int
+	Cwd     string
+	Argv    []string
+	Running bool
+	Error   string
+	Exit    Exit
+	Term    TermUpdate
+}
+type Hello struct {
+	Session string
+	Alias   []Pair
+	Env     []Pair
+	Cells   []CellState
+}
+type RowsResponse struct {
+	Rows []RowSpans
 }
 type Output struct {
-	// CmdError, TermUpdate, Exit
+	// CmdError, TermUpdate, Exit, SignalResult, InputError, RowsResponse
 	Alt Msg
 }
 type CellOutput struct {
@@ -157,10 +338,38 @@ type CellOutput struct {
 	Output Output
 }
 type ServerMsg struct {
-	// Hello, CompleteResponse, CellOutput
+	// Hello, CompleteResponse, CellOutput, ScrollbackResponse, SearchResponse
+	Alt Msg
+}
+type LocalRequest struct {
+	Command string
+	Args    []string
+}
+type LocalStdin struct {
+	Data []byte
+}
+type LocalClientMsg struct {
+	// LocalRequest, LocalStdin
+	Alt Msg
+}
+type LocalOutput struct {
+	Stderr bool
+	Data   []byte
+}
+type LocalExit struct {
+	Code int
+}
+type LocalServerMsg struct {
+	// LocalOutput, LocalExit
 	Alt Msg
 }
 
+func (msg *ClientHello) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Schema); err != nil {
+		return err
+	}
+	return nil
+}
 func (msg *ClientMessage) Write(w io.Writer) error {
 	switch alt := msg.Alt.(type) {
 	case *CompleteRequest:
@@ -178,8 +387,43 @@ func (msg *ClientMessage) Write(w io.Writer) error {
 			return err
 		}
 		return alt.Write(w)
+	case *Resize:
+		if err := WriteUint8(w, 4); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *MouseEvent:
+		if err := WriteUint8(w, 5); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *Paste:
+		if err := WriteUint8(w, 6); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *Signal:
+		if err := WriteUint8(w, 7); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *ScrollbackRequest:
+		if err := WriteUint8(w, 8); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *RowsRequest:
+		if err := WriteUint8(w, 9); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *SearchRequest:
+		if err := WriteUint8(w, 10); err != nil {
+			return err
+		}
+		return alt.Write(w)
 	}
-	panic("notimpl")
+	return fmt.Errorf("bad alt %T when writing ClientMessage", msg.Alt)
 }
 func (msg *CompleteRequest) Write(w io.Writer) error {
 	if err := WriteInt(w, msg.Id); err != nil {
@@ -216,6 +460,15 @@ func (msg *CompleteResponse) Write(w io.Writer) error {
 	}
 	return nil
 }
+func (msg *TermSize) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Rows); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Cols); err != nil {
+		return err
+	}
+	return nil
+}
 func (msg *RunRequest) Write(w io.Writer) error {
 	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
@@ -231,6 +484,31 @@ func (msg *RunRequest) Write(w io.Writer) error {
 			return err
 		}
 	}
+	if err := msg.Size.Write(w); err != nil {
+		return err
+	}
+	if err := WriteInt(w, len(msg.SetEnv)); err != nil {
+		return err
+	}
+	for _, val := range msg.SetEnv {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	if err := WriteInt(w, len(msg.UnsetEnv)); err != nil {
+		return err
+	}
+	for _, val := range msg.UnsetEnv {
+		if err := WriteString(w, val); err != nil {
+			return err
+		}
+	}
+	if err := WriteInt(w, msg.Scrollback); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.DropScrollback); err != nil {
+		return err
+	}
 	return nil
 }
 func (msg *KeyEvent) Write(w io.Writer) error {
@@ -242,42 +520,43 @@ func (msg *KeyEvent) Write(w io.Writer) error {
 	}
 	return nil
 }
-func (msg *RowSpans) Write(w io.Writer) error {
-	if err := WriteInt(w, msg.Row); err != nil {
+func (msg *Paste) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
 	}
-	if err := WriteInt(w, len(msg.Spans)); err != nil {
+	if err := WriteString(w, msg.Text); err != nil {
 		return err
 	}
-	for _, val := range msg.Spans {
-		if err := val.Write(w); err != nil {
-			return err
-		}
-	}
 	return nil
 }
-func (msg *Span) Write(w io.Writer) error {
-	if err := WriteInt(w, msg.Attr); err != nil {
+func (msg *Signal) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
 	}
-	if err := WriteString(w, msg.Text); err != nil {
+	if err := WriteString(w, msg.Signal); err != nil {
 		return err
 	}
 	return nil
 }
-func (msg *Cursor) Write(w io.Writer) error {
-	if err := WriteInt(w, msg.Row); err != nil {
+func (msg *ScrollbackRequest) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
 	}
-	if err := WriteInt(w, msg.Col); err != nil {
+	if err := WriteInt(w, msg.Start); err != nil {
 		return err
 	}
-	if err := WriteBoolean(w, msg.Hidden); err != nil {
+	if err := WriteInt(w, msg.End); err != nil {
 		return err
 	}
 	return nil
 }
-func (msg *TermUpdate) Write(w io.Writer) error {
+func (msg *ScrollbackResponse) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Error); err != nil {
+		return err
+	}
 	if err := WriteInt(w, len(msg.Rows)); err != nil {
 		return err
 	}
@@ -286,125 +565,547 @@ func (msg *TermUpdate) Write(w io.Writer) error {
 			return err
 		}
 	}
-	if err := msg.Cursor.Write(w); err != nil {
+	return nil
+}
+func (msg *RowsRequest) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
 	}
-	if err := WriteInt(w, msg.RowCount); err != nil {
+	if err := WriteInt(w, msg.Start); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.End); err != nil {
 		return err
 	}
 	return nil
 }
-func (msg *Pair) Write(w io.Writer) error {
-	if err := WriteString(w, msg.Key); err != nil {
+func (msg *SearchRequest) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Id); err != nil {
 		return err
 	}
-	if err := WriteString(w, msg.Val); err != nil {
+	if err := WriteString(w, msg.Query); err != nil {
 		return err
 	}
-	return nil
-}
-func (msg *Hello) Write(w io.Writer) error {
-	if err := WriteInt(w, len(msg.Alias)); err != nil {
+	if err := WriteBoolean(w, msg.Regex); err != nil {
 		return err
 	}
-	for _, val := range msg.Alias {
-		if err := val.Write(w); err != nil {
-			return err
-		}
+	if err := WriteBoolean(w, msg.IgnoreCase); err != nil {
+		return err
 	}
-	if err := WriteInt(w, len(msg.Env)); err != nil {
+	if err := WriteInt(w, len(msg.Cells)); err != nil {
 		return err
 	}
-	for _, val := range msg.Env {
-		if err := val.Write(w); err != nil {
+	for _, val := range msg.Cells {
+		if err := WriteInt(w, val); err != nil {
 			return err
 		}
 	}
 	return nil
 }
-func (msg *CmdError) Write(w io.Writer) error {
-	if err := WriteString(w, msg.Error); err != nil {
+func (msg *SearchMatch) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
 	}
-	return nil
-}
-func (msg *Exit) Write(w io.Writer) error {
-	if err := WriteInt(w, msg.ExitCode); err != nil {
+	if err := WriteInt(w, msg.Row); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.History); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Start); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.End); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Before); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Match); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.After); err != nil {
 		return err
 	}
 	return nil
 }
-func (msg *Output) Write(w io.Writer) error {
-	switch alt := msg.Alt.(type) {
-	case *CmdError:
-		if err := WriteUint8(w, 1); err != nil {
-			return err
-		}
-		return alt.Write(w)
-	case *TermUpdate:
-		if err := WriteUint8(w, 2); err != nil {
-			return err
-		}
-		return alt.Write(w)
-	case *Exit:
-		if err := WriteUint8(w, 3); err != nil {
+func (msg *SearchResponse) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Id); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Error); err != nil {
+		return err
+	}
+	if err := WriteInt(w, len(msg.Matches)); err != nil {
+		return err
+	}
+	for _, val := range msg.Matches {
+		if err := val.Write(w); err != nil {
 			return err
 		}
-		return alt.Write(w)
 	}
-	panic("notimpl")
+	if err := WriteBoolean(w, msg.Truncated); err != nil {
+		return err
+	}
+	return nil
 }
-func (msg *CellOutput) Write(w io.Writer) error {
+func (msg *Resize) Write(w io.Writer) error {
 	if err := WriteInt(w, msg.Cell); err != nil {
 		return err
 	}
-	if err := msg.Output.Write(w); err != nil {
+	if err := msg.Size.Write(w); err != nil {
 		return err
 	}
 	return nil
 }
-func (msg *ServerMsg) Write(w io.Writer) error {
-	switch alt := msg.Alt.(type) {
-	case *Hello:
-		if err := WriteUint8(w, 1); err != nil {
-			return err
-		}
-		return alt.Write(w)
-	case *CompleteResponse:
-		if err := WriteUint8(w, 2); err != nil {
-			return err
-		}
-		return alt.Write(w)
-	case *CellOutput:
-		if err := WriteUint8(w, 3); err != nil {
-			return err
-		}
-		return alt.Write(w)
+func (msg *MouseEvent) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
+		return err
 	}
-	panic("notimpl")
-}
-func (msg *ClientMessage) Read(r *bufio.Reader) error {
-	alt, err := r.ReadByte()
-	if err != nil {
+	if err := WriteUint8(w, msg.Action); err != nil {
 		return err
 	}
-	switch alt {
-	case 1:
-		var val CompleteRequest
-		if err := val.Read(r); err != nil {
-			return err
-		}
+	if err := WriteUint8(w, msg.Button); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Row); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Col); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.Shift); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.Alt); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.Ctrl); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *RowSpans) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Row); err != nil {
+		return err
+	}
+	if err := WriteInt(w, len(msg.Spans)); err != nil {
+		return err
+	}
+	for _, val := range msg.Spans {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (msg *Span) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Attr); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Fg); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Bg); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Text); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Cursor) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Row); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Col); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.Hidden); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *TermUpdate) Write(w io.Writer) error {
+	if err := WriteInt(w, len(msg.Rows)); err != nil {
+		return err
+	}
+	for _, val := range msg.Rows {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	if err := msg.Cursor.Write(w); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.RowCount); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.Trimmed); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.History); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.AltScreen); err != nil {
+		return err
+	}
+	if err := WriteUint8(w, msg.Mouse); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.AppCursorKeys); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.AppKeypad); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.BracketedPaste); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Pair) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Key); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Val); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *CmdError) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Error); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Exit) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.ExitCode); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Signal); err != nil {
+		return err
+	}
+	if err := WriteBoolean(w, msg.CoreDumped); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.WallMs); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.UserMs); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.SysMs); err != nil {
+		return err
+	}
+	if err := WriteInt(w, msg.MaxRssKb); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *InputError) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Error); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *SignalResult) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Signal); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Error); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *CellState) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Cwd); err != nil {
+		return err
+	}
+	if err := WriteInt(w, len(msg.Argv)); err != nil {
+		return err
+	}
+	for _, val := range msg.Argv {
+		if err := WriteString(w, val); err != nil {
+			return err
+		}
+	}
+	if err := WriteBoolean(w, msg.Running); err != nil {
+		return err
+	}
+	if err := WriteString(w, msg.Error); err != nil {
+		return err
+	}
+	if err := msg.Exit.Write(w); err != nil {
+		return err
+	}
+	if err := msg.Term.Write(w); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Hello) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Session); err != nil {
+		return err
+	}
+	if err := WriteInt(w, len(msg.Alias)); err != nil {
+		return err
+	}
+	for _, val := range msg.Alias {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	if err := WriteInt(w, len(msg.Env)); err != nil {
+		return err
+	}
+	for _, val := range msg.Env {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	if err := WriteInt(w, len(msg.Cells)); err != nil {
+		return err
+	}
+	for _, val := range msg.Cells {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (msg *RowsResponse) Write(w io.Writer) error {
+	if err := WriteInt(w, len(msg.Rows)); err != nil {
+		return err
+	}
+	for _, val := range msg.Rows {
+		if err := val.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (msg *Output) Write(w io.Writer) error {
+	switch alt := msg.Alt.(type) {
+	case *CmdError:
+		if err := WriteUint8(w, 1); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *TermUpdate:
+		if err := WriteUint8(w, 2); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *Exit:
+		if err := WriteUint8(w, 3); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *SignalResult:
+		if err := WriteUint8(w, 4); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *InputError:
+		if err := WriteUint8(w, 5); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *RowsResponse:
+		if err := WriteUint8(w, 6); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	}
+	return fmt.Errorf("bad alt %T when writing Output", msg.Alt)
+}
+func (msg *CellOutput) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Cell); err != nil {
+		return err
+	}
+	if err := msg.Output.Write(w); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *ServerMsg) Write(w io.Writer) error {
+	switch alt := msg.Alt.(type) {
+	case *Hello:
+		if err := WriteUint8(w, 1); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *CompleteResponse:
+		if err := WriteUint8(w, 2); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *CellOutput:
+		if err := WriteUint8(w, 3); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *ScrollbackResponse:
+		if err := WriteUint8(w, 4); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *SearchResponse:
+		if err := WriteUint8(w, 5); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	}
+	return fmt.Errorf("bad alt %T when writing ServerMsg", msg.Alt)
+}
+func (msg *LocalRequest) Write(w io.Writer) error {
+	if err := WriteString(w, msg.Command); err != nil {
+		return err
+	}
+	if err := WriteInt(w, len(msg.Args)); err != nil {
+		return err
+	}
+	for _, val := range msg.Args {
+		if err := WriteString(w, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (msg *LocalStdin) Write(w io.Writer) error {
+	if err := WriteBytes(w, msg.Data); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *LocalClientMsg) Write(w io.Writer) error {
+	switch alt := msg.Alt.(type) {
+	case *LocalRequest:
+		if err := WriteUint8(w, 1); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *LocalStdin:
+		if err := WriteUint8(w, 2); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	}
+	return fmt.Errorf("bad alt %T when writing LocalClientMsg", msg.Alt)
+}
+func (msg *LocalOutput) Write(w io.Writer) error {
+	if err := WriteBoolean(w, msg.Stderr); err != nil {
+		return err
+	}
+	if err := WriteBytes(w, msg.Data); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *LocalExit) Write(w io.Writer) error {
+	if err := WriteInt(w, msg.Code); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *LocalServerMsg) Write(w io.Writer) error {
+	switch alt := msg.Alt.(type) {
+	case *LocalOutput:
+		if err := WriteUint8(w, 1); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	case *LocalExit:
+		if err := WriteUint8(w, 2); err != nil {
+			return err
+		}
+		return alt.Write(w)
+	}
+	return fmt.Errorf("bad alt %T when writing LocalServerMsg", msg.Alt)
+}
+func (msg *ClientHello) Read(r *bufio.Reader) error {
+	var err error
+	msg.Schema, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *ClientMessage) Read(r *bufio.Reader) error {
+	alt, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch alt {
+	case 1:
+		var val CompleteRequest
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 2:
+		var val RunRequest
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 3:
+		var val KeyEvent
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 4:
+		var val Resize
+		if err := val.Read(r); err != nil {
+			return err
+		}
 		msg.Alt = &val
 		return nil
-	case 2:
-		var val RunRequest
+	case 5:
+		var val MouseEvent
 		if err := val.Read(r); err != nil {
 			return err
 		}
 		msg.Alt = &val
 		return nil
-	case 3:
-		var val KeyEvent
+	case 6:
+		var val Paste
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 7:
+		var val Signal
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 8:
+		var val ScrollbackRequest
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 9:
+		var val RowsRequest
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 10:
+		var val SearchRequest
 		if err := val.Read(r); err != nil {
 			return err
 		}
@@ -416,7 +1117,6 @@ func (msg *ClientMessage) Read(r *bufio.Reader) error {
 }
 func (msg *CompleteRequest) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Id, err = ReadInt(r)
 	if err != nil {
 		return err
@@ -437,7 +1137,6 @@ func (msg *CompleteRequest) Read(r *bufio.Reader) error {
 }
 func (msg *CompleteResponse) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Id, err = ReadInt(r)
 	if err != nil {
 		return err
@@ -455,8 +1154,8 @@ func (msg *CompleteResponse) Read(r *bufio.Reader) error {
 		if err != nil {
 			return err
 		}
-		var val string
 		for i := 0; i < n; i++ {
+			var val string
 			val, err = ReadString(r)
 			if err != nil {
 				return err
@@ -466,9 +1165,20 @@ func (msg *CompleteResponse) Read(r *bufio.Reader) error {
 	}
 	return nil
 }
+func (msg *TermSize) Read(r *bufio.Reader) error {
+	var err error
+	msg.Rows, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Cols, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
 func (msg *RunRequest) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Cell, err = ReadInt(r)
 	if err != nil {
 		return err
@@ -482,8 +1192,8 @@ func (msg *RunRequest) Read(r *bufio.Reader) error {
 		if err != nil {
 			return err
 		}
-		var val string
 		for i := 0; i < n; i++ {
+			var val string
 			val, err = ReadString(r)
 			if err != nil {
 				return err
@@ -491,16 +1201,280 @@ func (msg *RunRequest) Read(r *bufio.Reader) error {
 			msg.Argv = append(msg.Argv, val)
 		}
 	}
+	if err := msg.Size.Read(r); err != nil {
+		return err
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val Pair
+			if err := val.Read(r); err != nil {
+				return err
+			}
+			msg.SetEnv = append(msg.SetEnv, val)
+		}
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val string
+			val, err = ReadString(r)
+			if err != nil {
+				return err
+			}
+			msg.UnsetEnv = append(msg.UnsetEnv, val)
+		}
+	}
+	msg.Scrollback, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.DropScrollback, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 func (msg *KeyEvent) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Cell, err = ReadInt(r)
 	if err != nil {
 		return err
 	}
-	msg.Keys, err = ReadString(r)
+	msg.Keys, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Paste) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Text, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Signal) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Signal, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *ScrollbackRequest) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Start, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.End, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *ScrollbackResponse) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Error, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val RowSpans
+			if err := val.Read(r); err != nil {
+				return err
+			}
+			msg.Rows = append(msg.Rows, val)
+		}
+	}
+	return nil
+}
+func (msg *RowsRequest) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Start, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.End, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *SearchRequest) Read(r *bufio.Reader) error {
+	var err error
+	msg.Id, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Query, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	msg.Regex, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.IgnoreCase, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val int
+			val, err = ReadInt(r)
+			if err != nil {
+				return err
+			}
+			msg.Cells = append(msg.Cells, val)
+		}
+	}
+	return nil
+}
+func (msg *SearchMatch) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Row, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.History, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.Start, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.End, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Before, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	msg.Match, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	msg.After, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *SearchResponse) Read(r *bufio.Reader) error {
+	var err error
+	msg.Id, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Error, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val SearchMatch
+			if err := val.Read(r); err != nil {
+				return err
+			}
+			msg.Matches = append(msg.Matches, val)
+		}
+	}
+	msg.Truncated, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Resize) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	if err := msg.Size.Read(r); err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *MouseEvent) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Action, err = ReadUint8(r)
+	if err != nil {
+		return err
+	}
+	msg.Button, err = ReadUint8(r)
+	if err != nil {
+		return err
+	}
+	msg.Row, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Col, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Shift, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.Alt, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.Ctrl, err = ReadBoolean(r)
 	if err != nil {
 		return err
 	}
@@ -508,7 +1482,6 @@ func (msg *KeyEvent) Read(r *bufio.Reader) error {
 }
 func (msg *RowSpans) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Row, err = ReadInt(r)
 	if err != nil {
 		return err
@@ -518,8 +1491,8 @@ func (msg *RowSpans) Read(r *bufio.Reader) error {
 		if err != nil {
 			return err
 		}
-		var val Span
 		for i := 0; i < n; i++ {
+			var val Span
 			if err := val.Read(r); err != nil {
 				return err
 			}
@@ -530,11 +1503,18 @@ func (msg *RowSpans) Read(r *bufio.Reader) error {
 }
 func (msg *Span) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Attr, err = ReadInt(r)
 	if err != nil {
 		return err
 	}
+	msg.Fg, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Bg, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
 	msg.Text, err = ReadString(r)
 	if err != nil {
 		return err
@@ -543,7 +1523,6 @@ func (msg *Span) Read(r *bufio.Reader) error {
 }
 func (msg *Cursor) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Row, err = ReadInt(r)
 	if err != nil {
 		return err
@@ -560,14 +1539,13 @@ func (msg *Cursor) Read(r *bufio.Reader) error {
 }
 func (msg *TermUpdate) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	{
 		n, err := ReadInt(r)
 		if err != nil {
 			return err
 		}
-		var val RowSpans
 		for i := 0; i < n; i++ {
+			var val RowSpans
 			if err := val.Read(r); err != nil {
 				return err
 			}
@@ -581,11 +1559,38 @@ func (msg *TermUpdate) Read(r *bufio.Reader) error {
 	if err != nil {
 		return err
 	}
+	msg.Trimmed, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.History, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.AltScreen, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.Mouse, err = ReadUint8(r)
+	if err != nil {
+		return err
+	}
+	msg.AppCursorKeys, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.AppKeypad, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.BracketedPaste, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 func (msg *Pair) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Key, err = ReadString(r)
 	if err != nil {
 		return err
@@ -596,16 +1601,119 @@ func (msg *Pair) Read(r *bufio.Reader) error {
 	}
 	return nil
 }
+func (msg *CmdError) Read(r *bufio.Reader) error {
+	var err error
+	msg.Error, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *Exit) Read(r *bufio.Reader) error {
+	var err error
+	msg.ExitCode, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Signal, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	msg.CoreDumped, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.WallMs, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.UserMs, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.SysMs, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.MaxRssKb, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *InputError) Read(r *bufio.Reader) error {
+	var err error
+	msg.Error, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *SignalResult) Read(r *bufio.Reader) error {
+	var err error
+	msg.Signal, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	msg.Error, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *CellState) Read(r *bufio.Reader) error {
+	var err error
+	msg.Cell, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	msg.Cwd, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val string
+			val, err = ReadString(r)
+			if err != nil {
+				return err
+			}
+			msg.Argv = append(msg.Argv, val)
+		}
+	}
+	msg.Running, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.Error, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	if err := msg.Exit.Read(r); err != nil {
+		return err
+	}
+	if err := msg.Term.Read(r); err != nil {
+		return err
+	}
+	return nil
+}
 func (msg *Hello) Read(r *bufio.Reader) error {
 	var err error
-	err = err
+	msg.Session, err = ReadString(r)
+	if err != nil {
+		return err
+	}
 	{
 		n, err := ReadInt(r)
 		if err != nil {
 			return err
 		}
-		var val Pair
 		for i := 0; i < n; i++ {
+			var val Pair
 			if err := val.Read(r); err != nil {
 				return err
 			}
@@ -617,31 +1725,42 @@ func (msg *Hello) Read(r *bufio.Reader) error {
 		if err != nil {
 			return err
 		}
-		var val Pair
 		for i := 0; i < n; i++ {
+			var val Pair
 			if err := val.Read(r); err != nil {
 				return err
 			}
 			msg.Env = append(msg.Env, val)
 		}
 	}
-	return nil
-}
-func (msg *CmdError) Read(r *bufio.Reader) error {
-	var err error
-	err = err
-	msg.Error, err = ReadString(r)
-	if err != nil {
-		return err
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val CellState
+			if err := val.Read(r); err != nil {
+				return err
+			}
+			msg.Cells = append(msg.Cells, val)
+		}
 	}
 	return nil
 }
-func (msg *Exit) Read(r *bufio.Reader) error {
-	var err error
-	err = err
-	msg.ExitCode, err = ReadInt(r)
-	if err != nil {
-		return err
+func (msg *RowsResponse) Read(r *bufio.Reader) error {
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val RowSpans
+			if err := val.Read(r); err != nil {
+				return err
+			}
+			msg.Rows = append(msg.Rows, val)
+		}
 	}
 	return nil
 }
@@ -672,13 +1791,33 @@ func (msg *Output) Read(r *bufio.Reader) error {
 		}
 		msg.Alt = &val
 		return nil
+	case 4:
+		var val SignalResult
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 5:
+		var val InputError
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 6:
+		var val RowsResponse
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
 	default:
 		return fmt.Errorf("bad tag %d when reading Output", alt)
 	}
 }
 func (msg *CellOutput) Read(r *bufio.Reader) error {
 	var err error
-	err = err
 	msg.Cell, err = ReadInt(r)
 	if err != nil {
 		return err
@@ -715,7 +1854,119 @@ func (msg *ServerMsg) Read(r *bufio.Reader) error {
 		}
 		msg.Alt = &val
 		return nil
+	case 4:
+		var val ScrollbackResponse
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 5:
+		var val SearchResponse
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
 	default:
 		return fmt.Errorf("bad tag %d when reading ServerMsg", alt)
 	}
 }
+func (msg *LocalRequest) Read(r *bufio.Reader) error {
+	var err error
+	msg.Command, err = ReadString(r)
+	if err != nil {
+		return err
+	}
+	{
+		n, err := ReadInt(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			var val string
+			val, err = ReadString(r)
+			if err != nil {
+				return err
+			}
+			msg.Args = append(msg.Args, val)
+		}
+	}
+	return nil
+}
+func (msg *LocalStdin) Read(r *bufio.Reader) error {
+	var err error
+	msg.Data, err = ReadBytes(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *LocalClientMsg) Read(r *bufio.Reader) error {
+	alt, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch alt {
+	case 1:
+		var val LocalRequest
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 2:
+		var val LocalStdin
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	default:
+		return fmt.Errorf("bad tag %d when reading LocalClientMsg", alt)
+	}
+}
+func (msg *LocalOutput) Read(r *bufio.Reader) error {
+	var err error
+	msg.Stderr, err = ReadBoolean(r)
+	if err != nil {
+		return err
+	}
+	msg.Data, err = ReadBytes(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *LocalExit) Read(r *bufio.Reader) error {
+	var err error
+	msg.Code, err = ReadInt(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+func (msg *LocalServerMsg) Read(r *bufio.Reader) error {
+	alt, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch alt {
+	case 1:
+		var val LocalOutput
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	case 2:
+		var val LocalExit
+		if err := val.Read(r); err != nil {
+			return err
+		}
+		msg.Alt = &val
+		return nil
+	default:
+		return fmt.Errorf("bad tag %d when reading LocalServerMsg", alt)
+	}
+}
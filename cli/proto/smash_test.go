@@ -0,0 +1,305 @@
+package proto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMsgs constructs an empty value of each generated type, by name.
+var newMsgs = map[string]func() Msg{
+	"ClientHello":        func() Msg { return &ClientHello{} },
+	"ClientMessage":      func() Msg { return &ClientMessage{} },
+	"CompleteRequest":    func() Msg { return &CompleteRequest{} },
+	"CompleteResponse":   func() Msg { return &CompleteResponse{} },
+	"TermSize":           func() Msg { return &TermSize{} },
+	"RunRequest":         func() Msg { return &RunRequest{} },
+	"KeyEvent":           func() Msg { return &KeyEvent{} },
+	"Paste":              func() Msg { return &Paste{} },
+	"Signal":             func() Msg { return &Signal{} },
+	"ScrollbackRequest":  func() Msg { return &ScrollbackRequest{} },
+	"ScrollbackResponse": func() Msg { return &ScrollbackResponse{} },
+	"RowsRequest":        func() Msg { return &RowsRequest{} },
+	"SearchRequest":      func() Msg { return &SearchRequest{} },
+	"SearchMatch":        func() Msg { return &SearchMatch{} },
+	"SearchResponse":     func() Msg { return &SearchResponse{} },
+	"Resize":             func() Msg { return &Resize{} },
+	"MouseEvent":         func() Msg { return &MouseEvent{} },
+	"RowSpans":           func() Msg { return &RowSpans{} },
+	"Span":               func() Msg { return &Span{} },
+	"Cursor":             func() Msg { return &Cursor{} },
+	"TermUpdate":         func() Msg { return &TermUpdate{} },
+	"Pair":               func() Msg { return &Pair{} },
+	"CmdError":           func() Msg { return &CmdError{} },
+	"Exit":               func() Msg { return &Exit{} },
+	"InputError":         func() Msg { return &InputError{} },
+	"SignalResult":       func() Msg { return &SignalResult{} },
+	"CellState":          func() Msg { return &CellState{} },
+	"Hello":              func() Msg { return &Hello{} },
+	"RowsResponse":       func() Msg { return &RowsResponse{} },
+	"Output":             func() Msg { return &Output{} },
+	"CellOutput":         func() Msg { return &CellOutput{} },
+	"ServerMsg":          func() Msg { return &ServerMsg{} },
+	"LocalRequest":       func() Msg { return &LocalRequest{} },
+	"LocalStdin":         func() Msg { return &LocalStdin{} },
+	"LocalClientMsg":     func() Msg { return &LocalClientMsg{} },
+	"LocalOutput":        func() Msg { return &LocalOutput{} },
+	"LocalExit":          func() Msg { return &LocalExit{} },
+	"LocalServerMsg":     func() Msg { return &LocalServerMsg{} },
+}
+
+// generated parses smash.go for the names of the generated types, and the
+// alternatives of each union type in tag order, so that the tests cover
+// every type without relying on newMsgs being complete.
+func generated(t testing.TB) ([]string, map[string][]string) {
+	f, err := parser.ParseFile(token.NewFileSet(), "smash.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	alts := map[string][]string{}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Name.Name != "Write" {
+			continue
+		}
+		name := fn.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name
+		names = append(names, name)
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if c, ok := n.(*ast.CaseClause); ok {
+				for _, expr := range c.List {
+					alt := expr.(*ast.StarExpr).X.(*ast.Ident).Name
+					alts[name] = append(alts[name], alt)
+				}
+			}
+			return true
+		})
+	}
+	for _, name := range names {
+		if newMsgs[name] == nil {
+			t.Fatalf("no constructor for %s; add it to newMsgs", name)
+		}
+	}
+	return names, alts
+}
+
+// generator fills in messages with random values.
+type generator struct {
+	r    *rand.Rand
+	alts map[string][]string
+	// long allows strings too long for a short seed input.
+	long bool
+}
+
+func (g *generator) msg(name string) Msg {
+	msg := newMsgs[name]()
+	g.fill(reflect.ValueOf(msg).Elem())
+	return msg
+}
+
+func (g *generator) bytes() []byte {
+	n := g.r.Intn(20)
+	if g.long && g.r.Intn(50) == 0 {
+		// Longer than strings were once limited to, and than ReadBytes
+		// allocates up front.
+		n = 100 << 10
+	}
+	buf := make([]byte, n)
+	g.r.Read(buf)
+	return buf
+}
+
+func (g *generator) fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(g.r.Intn(2) == 1)
+	case reflect.Uint8:
+		v.SetUint(uint64(g.r.Intn(256)))
+	case reflect.Int:
+		// Vary the magnitude, to cover varints of every length.
+		v.SetInt(g.r.Int63() >> uint(g.r.Intn(64)))
+	case reflect.String:
+		v.SetString(string(g.bytes()))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(g.bytes())
+			return
+		}
+		// Leave empty arrays nil, as Read does.
+		if n := g.r.Intn(4); n > 0 {
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+			for i := 0; i < n; i++ {
+				g.fill(v.Index(i))
+			}
+		}
+	case reflect.Struct:
+		if alts, ok := g.alts[v.Type().Name()]; ok {
+			v.Field(0).Set(reflect.ValueOf(g.msg(alts[g.r.Intn(len(alts))])))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			g.fill(v.Field(i))
+		}
+	default:
+		panic("unhandled kind " + v.Kind().String())
+	}
+}
+
+func encode(t testing.TB, msg Msg) []byte {
+	buf := &bytes.Buffer{}
+	if err := msg.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	names, alts := generated(t)
+	for _, name := range names {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			roundTrips := func(msg Msg) bool {
+				buf := encode(t, msg)
+				got := newMsgs[name]()
+				br := bufio.NewReader(bytes.NewReader(buf))
+				if err := got.Read(br); err != nil {
+					t.Errorf("read: %s", err)
+					return false
+				}
+				if _, err := br.ReadByte(); err != io.EOF {
+					t.Errorf("read left input unread")
+					return false
+				}
+				if !reflect.DeepEqual(msg, got) {
+					t.Errorf("read back a different message")
+					return false
+				}
+				// A truncated message must fail to read.
+				cut := r.Intn(len(buf))
+				if err := newMsgs[name]().Read(bufio.NewReader(bytes.NewReader(buf[:cut]))); err == nil {
+					t.Errorf("read of %d of %d bytes succeeded", cut, len(buf))
+					return false
+				}
+				return true
+			}
+			err := quick.Check(roundTrips, &quick.Config{
+				Rand: r,
+				Values: func(args []reflect.Value, r *rand.Rand) {
+					g := &generator{r: r, alts: alts, long: true}
+					args[0] = reflect.ValueOf(g.msg(name))
+				},
+			})
+			if cerr, ok := err.(*quick.CheckError); ok {
+				// The default error prints the input, which may be huge.
+				t.Fatalf("failed on input #%d", cerr.Count)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUnionAlternatives(t *testing.T) {
+	_, alts := generated(t)
+	for name, names := range alts {
+		// A tag past the last alternative is an error, not a panic.
+		tag := len(names) + 1
+		err := newMsgs[name]().Read(bufio.NewReader(bytes.NewReader([]byte{byte(tag)})))
+		assert.EqualError(t, err, fmt.Sprintf("bad tag %d when reading %s", tag, name))
+		assert.Error(t, newMsgs[name]().Write(&bytes.Buffer{}), "writing %s with no Alt", name)
+	}
+}
+
+func TestInts(t *testing.T) {
+	for _, val := range []int{0, 1, 127, 128, math.MaxInt32, math.MaxInt64} {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, WriteInt(buf, val))
+		got, err := ReadInt(bufio.NewReader(buf))
+		assert.NoError(t, err)
+		assert.Equal(t, val, got)
+	}
+	for _, val := range []int{0, 1, -1, 63, -64, 64, math.MaxInt64, math.MinInt64} {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, WriteSint(buf, val))
+		got, err := ReadSint(bufio.NewReader(buf))
+		assert.NoError(t, err)
+		assert.Equal(t, val, got)
+	}
+	assert.Error(t, WriteInt(&bytes.Buffer{}, -1))
+}
+
+func TestReadIntBounds(t *testing.T) {
+	read := func(input ...byte) error {
+		_, err := ReadInt(bufio.NewReader(bytes.NewReader(input)))
+		return err
+	}
+	// 1<<64 - 1 is a valid varint, but not a valid int.
+	assert.EqualError(t, read(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01), "int 18446744073709551615 out of range")
+	// Bits past 64.
+	assert.EqualError(t, read(0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02), "varint overflows 64 bits")
+	// Too many bytes, even if the extra bits are zero.
+	assert.EqualError(t, read(0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00), "varint overflows 64 bits")
+	assert.Equal(t, io.EOF, read(0x80))
+}
+
+func TestReadBytesBounds(t *testing.T) {
+	// A length of 1<<40 followed by only a few bytes of data.
+	buf := &bytes.Buffer{}
+	assert.NoError(t, WriteInt(buf, 1<<40))
+	buf.WriteString("abc")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadBytes(bufio.NewReader(buf))
+	runtime.ReadMemStats(&after)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("allocated %d bytes reading 3 bytes", alloc)
+	}
+}
+
+// fuzzRead checks that reading arbitrary input as the named type never
+// panics, and that whatever it reads writes back out the same.
+func fuzzRead(f *testing.F, name string) {
+	_, alts := generated(f)
+	g := &generator{r: rand.New(rand.NewSource(1)), alts: alts}
+	for i := 0; i < 20; i++ {
+		f.Add(encode(f, g.msg(name)))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := newMsgs[name]()
+		if err := msg.Read(bufio.NewReader(bytes.NewReader(data))); err != nil {
+			return
+		}
+		got := newMsgs[name]()
+		if err := got.Read(bufio.NewReader(bytes.NewReader(encode(t, msg)))); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, msg, got)
+	})
+}
+
+func FuzzClientMessage(f *testing.F) {
+	fuzzRead(f, "ClientMessage")
+}
+
+func FuzzServerMsg(f *testing.F) {
+	fuzzRead(f, "ServerMsg")
+}
+
+func FuzzLocalClientMsg(f *testing.F) {
+	fuzzRead(f, "LocalClientMsg")
+}
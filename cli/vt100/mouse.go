@@ -0,0 +1,163 @@
+package vt100
+
+import "fmt"
+
+// MouseMode is the kind of mouse reporting requested by the program.
+type MouseMode int
+
+const (
+	MouseNone MouseMode = iota
+	// MouseX10 (mode 9) reports button presses only.
+	MouseX10
+	// MouseNormal (mode 1000) reports presses and releases.
+	MouseNormal
+	// MouseButton (mode 1002) also reports motion while a button is down.
+	MouseButton
+	// MouseAny (mode 1003) reports all motion.
+	MouseAny
+)
+
+// MouseEncoding is the format of mouse reports.
+type MouseEncoding int
+
+const (
+	// MouseEncodingX10 is the default, packing values into bytes,
+	// which limits coordinates to 223.
+	MouseEncodingX10 MouseEncoding = iota
+	// MouseEncodingSGR (mode 1006) reports "\e[<b;x;yM", with a final
+	// 'm' for releases.
+	MouseEncodingSGR
+	// MouseEncodingURXVT (mode 1015) reports "\e[b;x;yM".
+	MouseEncodingURXVT
+)
+
+// MouseAction is what happened in a MouseEvent.
+type MouseAction int
+
+const (
+	MousePress MouseAction = iota
+	MouseRelease
+	MouseMove
+)
+
+// Mouse buttons, numbered as in DOM MouseEvent.button where possible.
+const (
+	MouseLeft      = 0
+	MouseMiddle    = 1
+	MouseRight     = 2
+	MouseNoButton  = 3
+	MouseWheelUp   = 4
+	MouseWheelDown = 5
+)
+
+// MouseEvent is a mouse action at a 0-based screen position.
+type MouseEvent struct {
+	Action           MouseAction
+	Button           int
+	Row, Col         int
+	Shift, Alt, Ctrl bool
+}
+
+// mouseModes maps DEC private modes to the mouse modes they enable.
+var mouseModes = map[int]MouseMode{
+	9:    MouseX10,
+	1000: MouseNormal,
+	1002: MouseButton,
+	1003: MouseAny,
+}
+
+// mouseEncodings maps DEC private modes to the encodings they enable.
+var mouseEncodings = map[int]MouseEncoding{
+	1006: MouseEncodingSGR,
+	1015: MouseEncodingURXVT,
+}
+
+// setMouseMode handles a DEC private mode that controls mouse reporting.
+// Resetting a mode only has an effect if that mode is active.
+func (t *Terminal) setMouseMode(mode int, set bool) {
+	if m, ok := mouseModes[mode]; ok {
+		if set {
+			t.Mouse = m
+		} else if t.Mouse == m {
+			t.Mouse = MouseNone
+		}
+	}
+	if enc, ok := mouseEncodings[mode]; ok {
+		if set {
+			t.MouseEncoding = enc
+		} else if t.MouseEncoding == enc {
+			t.MouseEncoding = MouseEncodingX10
+		}
+	}
+}
+
+// EncodeMouse returns the bytes to send to the program for a mouse event,
+// or nil if the program didn't ask for this kind of event.
+func (t *Terminal) EncodeMouse(ev MouseEvent) []byte {
+	if ev.Row < 0 || ev.Row >= t.Height || ev.Col < 0 || ev.Col >= t.Width {
+		return nil
+	}
+	switch t.Mouse {
+	case MouseNone:
+		return nil
+	case MouseX10:
+		if ev.Action != MousePress {
+			return nil
+		}
+	case MouseNormal:
+		if ev.Action == MouseMove {
+			return nil
+		}
+	case MouseButton:
+		if ev.Action == MouseMove && ev.Button == MouseNoButton {
+			return nil
+		}
+	}
+	isWheel := ev.Button == MouseWheelUp || ev.Button == MouseWheelDown
+	if isWheel && ev.Action != MousePress {
+		return nil
+	}
+
+	var code int
+	switch {
+	case isWheel:
+		code = 64 + ev.Button - MouseWheelUp
+	case ev.Action == MouseRelease && t.MouseEncoding != MouseEncodingSGR:
+		// Only SGR encoding says which button was released.
+		code = 3
+	default:
+		code = ev.Button
+	}
+	if ev.Action == MouseMove {
+		code += 32
+	}
+	if t.Mouse != MouseX10 {
+		if ev.Shift {
+			code |= 4
+		}
+		if ev.Alt {
+			code |= 8
+		}
+		if ev.Ctrl {
+			code |= 16
+		}
+	}
+
+	x, y := ev.Col+1, ev.Row+1
+	switch t.MouseEncoding {
+	case MouseEncodingSGR:
+		final := 'M'
+		if ev.Action == MouseRelease {
+			final = 'm'
+		}
+		return []byte(fmt.Sprintf("\x1b[<%d;%d;%d%c", code, x, y, final))
+	case MouseEncodingURXVT:
+		return []byte(fmt.Sprintf("\x1b[%d;%d;%dM", 32+code, x, y))
+	default:
+		if 32+x > 255 || 32+y > 255 {
+			// Not representable.
+			return nil
+		}
+		return []byte{0x1b, '[', 'M', byte(32 + code), byte(32 + x), byte(32 + y)}
+	}
+}
@@ -0,0 +1,96 @@
+package vt100
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMouseModes(t *testing.T) {
+	term, tr := newTestTerminal()
+	assert.Equal(t, MouseNone, term.Mouse)
+	mustRun(t, tr, "\x1b[?1002;1006h")
+	assert.Equal(t, MouseButton, term.Mouse)
+	assert.Equal(t, MouseEncodingSGR, term.MouseEncoding)
+
+	// Resetting a mode that isn't active does nothing.
+	mustRun(t, tr, "\x1b[?1000l\x1b[?1015l")
+	assert.Equal(t, MouseButton, term.Mouse)
+	assert.Equal(t, MouseEncodingSGR, term.MouseEncoding)
+
+	mustRun(t, tr, "\x1b[?1002;1006l")
+	assert.Equal(t, MouseNone, term.Mouse)
+	assert.Equal(t, MouseEncodingX10, term.MouseEncoding)
+}
+
+func TestEncodeMouseNone(t *testing.T) {
+	term := NewTerminal()
+	assert.Nil(t, term.EncodeMouse(MouseEvent{Action: MousePress}))
+}
+
+func TestEncodeMouseX10(t *testing.T) {
+	term := NewTerminal()
+	term.Mouse = MouseNormal
+	press := MouseEvent{Action: MousePress, Button: MouseLeft, Row: 2, Col: 4}
+	assert.Equal(t, "\x1b[M\x20\x25\x23", string(term.EncodeMouse(press)))
+
+	release := press
+	release.Action = MouseRelease
+	assert.Equal(t, "\x1b[M\x23\x25\x23", string(term.EncodeMouse(release)))
+
+	// Motion isn't reported in normal mode.
+	move := press
+	move.Action = MouseMove
+	assert.Nil(t, term.EncodeMouse(move))
+
+	wheel := MouseEvent{Action: MousePress, Button: MouseWheelDown, Ctrl: true}
+	assert.Equal(t, "\x1b[M\x71\x21\x21", string(term.EncodeMouse(wheel)))
+
+	// Out of range coordinates can't be encoded.
+	term.Width = 300
+	far := press
+	far.Col = 250
+	assert.Nil(t, term.EncodeMouse(far))
+}
+
+func TestEncodeMouseX10Mode(t *testing.T) {
+	term := NewTerminal()
+	term.Mouse = MouseX10
+	press := MouseEvent{Action: MousePress, Button: MouseRight, Shift: true}
+	// X10 mode doesn't report modifiers or releases.
+	assert.Equal(t, "\x1b[M\x22\x21\x21", string(term.EncodeMouse(press)))
+	press.Action = MouseRelease
+	assert.Nil(t, term.EncodeMouse(press))
+}
+
+func TestEncodeMouseSGR(t *testing.T) {
+	term := NewTerminal()
+	term.Mouse = MouseButton
+	term.MouseEncoding = MouseEncodingSGR
+	ev := MouseEvent{Action: MousePress, Button: MouseMiddle, Row: 9, Col: 19, Alt: true}
+	assert.Equal(t, "\x1b[<9;20;10M", string(term.EncodeMouse(ev)))
+	ev.Action = MouseRelease
+	assert.Equal(t, "\x1b[<9;20;10m", string(term.EncodeMouse(ev)))
+
+	// Motion is reported only while a button is down.
+	drag := MouseEvent{Action: MouseMove, Button: MouseLeft}
+	assert.Equal(t, "\x1b[<32;1;1M", string(term.EncodeMouse(drag)))
+	drag.Button = MouseNoButton
+	assert.Nil(t, term.EncodeMouse(drag))
+	term.Mouse = MouseAny
+	assert.Equal(t, "\x1b[<35;1;1M", string(term.EncodeMouse(drag)))
+
+	// Events off screen are dropped.
+	ev.Row = term.Height
+	assert.Nil(t, term.EncodeMouse(ev))
+}
+
+func TestEncodeMouseURXVT(t *testing.T) {
+	term := NewTerminal()
+	term.Mouse = MouseNormal
+	term.MouseEncoding = MouseEncodingURXVT
+	ev := MouseEvent{Action: MousePress, Button: MouseLeft, Row: 1, Col: 2}
+	assert.Equal(t, "\x1b[32;3;2M", string(term.EncodeMouse(ev)))
+	ev.Action = MouseRelease
+	assert.Equal(t, "\x1b[35;3;2M", string(term.EncodeMouse(ev)))
+}
@@ -11,75 +11,155 @@ import (
 	"unicode/utf8"
 )
 
-// Bits is a uint16 with some bitfield accessors.
-type Bits uint16
+// Bits is a uint64 with some bitfield accessors.
+type Bits uint64
 
-func (b Bits) Get(ofs uint, count uint) uint16 {
-	return (uint16(b) >> ofs) & (uint16(1<<count) - 1)
+func (b Bits) Get(ofs uint, count uint) uint64 {
+	return (uint64(b) >> ofs) & (uint64(1<<count) - 1)
 }
 func (b *Bits) Set(ofs uint, count uint, val uint) {
-	mask := (uint16(1<<count) - 1) << ofs
-	*b = Bits((uint16(*b) & ^uint16(mask)) | uint16(val)<<ofs)
+	mask := (uint64(1<<count) - 1) << ofs
+	*b = Bits((uint64(*b) & ^uint64(mask)) | uint64(val)<<ofs)
 }
 
-// Attr represents per-cell terminal attributes.
-// Bit layout is:
-// xxxx xxIB AAAA CCCC
-//  I = inverse
-//  B = bright
-//  A = background color
-//  C = foreground color
-type Attr Bits
+// Colors are encoded as ints:
+//
+//	0             default color
+//	1-256         indexed colors 0-255, where 1-8 are the basic ANSI colors
+//	ColorRGB|rgb  direct color, with rgb as 0xRRGGBB
+const ColorRGB = 1 << 24
 
-func (a Attr) Color() int {
-	return int(Bits(a).Get(0, 4))
+// colorBits is the width of an encoded color within an Attr.
+const colorBits = 25
+
+// IndexedColor encodes a 256-color palette index.
+func IndexedColor(index int) int {
+	return index + 1
 }
-func (a *Attr) SetColor(color int) {
-	(*Bits)(a).Set(0, 4, uint(color))
+
+// RGBColor encodes a direct color.
+func RGBColor(r, g, b int) int {
+	return ColorRGB | r<<16 | g<<8 | b
 }
 
-func (a Attr) Bright() bool {
-	return Bits(a).Get(8, 1) != 0
+func validColor(c int) bool {
+	return (c >= 0 && c <= 256) || (c&^0xFFFFFF == ColorRGB)
 }
-func (a *Attr) SetBright(bright bool) {
+
+// Attr represents per-cell terminal attributes.
+// Bit layout, from the low bit, is:
+//
+//	0-24   foreground color
+//	25-49  background color
+//	50-    attribute flags, as returned by Flags
+type Attr Bits
+
+const attrFlagsOfs = 2 * colorBits
+
+// Bit offsets of the attribute flags, relative to attrFlagsOfs.
+const (
+	attrBright        = 0
+	attrInverse       = 1
+	attrFaint         = 2
+	attrItalic        = 3
+	attrUnderline     = 4 // 3 bits, an Underline
+	attrBlink         = 7
+	attrHidden        = 8
+	attrStrikethrough = 9
+	attrFlagBits      = 10
+)
+
+// Underline is the style of underline on a cell.
+type Underline int
+
+const (
+	UnderlineNone Underline = iota
+	UnderlineSingle
+	UnderlineDouble
+	UnderlineCurly
+	UnderlineDotted
+	UnderlineDashed
+)
+
+func (a Attr) flag(bit uint) bool {
+	return Bits(a).Get(attrFlagsOfs+bit, 1) != 0
+}
+func (a *Attr) setFlag(bit uint, on bool) {
 	flag := uint(0)
-	if bright {
+	if on {
 		flag = 1
 	}
-	(*Bits)(a).Set(8, 1, flag)
+	(*Bits)(a).Set(attrFlagsOfs+bit, 1, flag)
 }
 
-func (a Attr) Inverse() bool {
-	return Bits(a).Get(9, 1) != 0
+func (a Attr) Color() int {
+	return int(Bits(a).Get(0, colorBits))
 }
-func (a *Attr) SetInverse(inverse bool) {
-	flag := uint(0)
-	if inverse {
-		flag = 1
-	}
-	(*Bits)(a).Set(9, 1, flag)
+func (a *Attr) SetColor(color int) {
+	(*Bits)(a).Set(0, colorBits, uint(color))
 }
 
 func (a Attr) BackColor() int {
-	return int(Bits(a).Get(4, 4))
+	return int(Bits(a).Get(colorBits, colorBits))
 }
 func (a *Attr) SetBackColor(color int) {
-	(*Bits)(a).Set(4, 4, uint(color))
+	(*Bits)(a).Set(colorBits, colorBits, uint(color))
+}
+
+func (a Attr) Bright() bool              { return a.flag(attrBright) }
+func (a *Attr) SetBright(bright bool)    { a.setFlag(attrBright, bright) }
+func (a Attr) Inverse() bool             { return a.flag(attrInverse) }
+func (a *Attr) SetInverse(inverse bool)  { a.setFlag(attrInverse, inverse) }
+func (a Attr) Faint() bool               { return a.flag(attrFaint) }
+func (a *Attr) SetFaint(faint bool)      { a.setFlag(attrFaint, faint) }
+func (a Attr) Italic() bool              { return a.flag(attrItalic) }
+func (a *Attr) SetItalic(italic bool)    { a.setFlag(attrItalic, italic) }
+func (a Attr) Blink() bool               { return a.flag(attrBlink) }
+func (a *Attr) SetBlink(blink bool)      { a.setFlag(attrBlink, blink) }
+func (a Attr) Hidden() bool              { return a.flag(attrHidden) }
+func (a *Attr) SetHidden(hidden bool)    { a.setFlag(attrHidden, hidden) }
+func (a Attr) Strikethrough() bool       { return a.flag(attrStrikethrough) }
+func (a *Attr) SetStrikethrough(on bool) { a.setFlag(attrStrikethrough, on) }
+
+func (a Attr) Underline() Underline {
+	return Underline(Bits(a).Get(attrFlagsOfs+attrUnderline, 3))
+}
+func (a *Attr) SetUnderline(u Underline) {
+	(*Bits)(a).Set(attrFlagsOfs+attrUnderline, 3, uint(u))
+}
+
+// Flags returns the non-color attributes as a bitfield:
+//
+//	0    bright
+//	1    inverse
+//	2    faint
+//	3    italic
+//	4-6  underline style, an Underline
+//	7    blink
+//	8    hidden
+//	9    strikethrough
+func (a Attr) Flags() int {
+	return int(uint64(a) >> attrFlagsOfs)
 }
 
 func (a Attr) Validate() error {
-	if c := a.Color(); c < 0 || c > 8 {
+	if c := a.Color(); !validColor(c) {
 		return fmt.Errorf("%s: bad color", a)
 	}
-	if c := a.BackColor(); c < 0 || c > 8 {
+	if c := a.BackColor(); !validColor(c) {
 		return fmt.Errorf("%s: bad back color", a)
 	}
-	if uint16(a)&0xFC00 != 0 {
+	if a.Underline() > UnderlineDashed {
+		return fmt.Errorf("%s: bad underline", a)
+	}
+	if a.Flags()>>attrFlagBits != 0 {
 		return fmt.Errorf("%s: extra bits", a)
 	}
 	return nil
 }
 
+var underlineNames = []string{"", "underline", "double-underline", "curly-underline", "dotted-underline", "dashed-underline"}
+
 func (a Attr) String() string {
 	fields := []string{}
 	if a.Inverse() {
@@ -88,15 +168,44 @@ func (a Attr) String() string {
 	if a.Bright() {
 		fields = append(fields, "bright")
 	}
+	if a.Faint() {
+		fields = append(fields, "faint")
+	}
+	if a.Italic() {
+		fields = append(fields, "italic")
+	}
+	if u := a.Underline(); u != UnderlineNone {
+		if int(u) < len(underlineNames) {
+			fields = append(fields, underlineNames[u])
+		} else {
+			fields = append(fields, fmt.Sprintf("underline:%d", u))
+		}
+	}
+	if a.Blink() {
+		fields = append(fields, "blink")
+	}
+	if a.Hidden() {
+		fields = append(fields, "hidden")
+	}
+	if a.Strikethrough() {
+		fields = append(fields, "strikethrough")
+	}
 	if fg := a.Color(); fg != 0 {
-		fields = append(fields, fmt.Sprintf("fg:%d", fg))
+		fields = append(fields, "fg:"+colorString(fg))
 	}
 	if bg := a.BackColor(); bg != 0 {
-		fields = append(fields, fmt.Sprintf("bg:%d", bg))
+		fields = append(fields, "bg:"+colorString(bg))
 	}
 	return fmt.Sprintf("Attr{%s}", strings.Join(fields, ","))
 }
 
+func colorString(c int) string {
+	if c&ColorRGB != 0 {
+		return fmt.Sprintf("#%06x", c&0xFFFFFF)
+	}
+	return fmt.Sprintf("%d", c)
+}
+
 func showChar(ch byte) string {
 	if ch >= ' ' && ch <= '~' {
 		return fmt.Sprintf("'%c'", ch)
@@ -106,13 +215,36 @@ func showChar(ch byte) string {
 }
 
 // Cell is a single character cell in the rendered terminal.
+// A wide character takes two cells, where the second has a Ch of 0.
 type Cell struct {
 	Ch   rune
 	Attr Attr
+	// Combining holds any zero-width characters that follow Ch, such as
+	// combining accents.
+	Combining string
 }
 
 func (c Cell) String() string {
-	return fmt.Sprintf("Cell{%q, %s}", c.Ch, c.Attr)
+	return fmt.Sprintf("Cell{%q, %s}", c.Text(), c.Attr)
+}
+
+// Text returns the text displayed in the cell, which is empty for the
+// second half of a wide character.
+func (c Cell) Text() string {
+	if c.Ch == 0 {
+		return ""
+	}
+	return string(c.Ch) + c.Combining
+}
+
+// WriteText appends the cell's Text to b, without allocating a string
+// per cell.
+func (c Cell) WriteText(b *strings.Builder) {
+	if c.Ch == 0 {
+		return
+	}
+	b.WriteRune(c.Ch)
+	b.WriteString(c.Combining)
 }
 
 // FeatureLog records missing terminal features as TODOs.
@@ -147,6 +279,35 @@ type Terminal struct {
 
 	// Saved versions of Row/Col for the control sequence that saves/restores position.
 	SaveRow, SaveCol int
+
+	// The scrolling region (DECSTBM) as 0-based screen rows, with
+	// ScrollBottom exclusive.  A ScrollBottom of 0 means the region
+	// extends to the bottom of the screen.
+	ScrollTop, ScrollBottom int
+
+	// Primary holds the primary screen while the alternate screen is
+	// active, and is nil otherwise.
+	Primary *Screen
+
+	// AppCursorKeys (DECCKM) means cursor keys send SS3 rather than CSI
+	// sequences, and AppKeypad (DECKPAM) means keypad keys send SS3
+	// sequences rather than their characters.
+	AppCursorKeys, AppKeypad bool
+
+	// BracketedPaste means the program wants pasted text marked; see
+	// EncodePaste.
+	BracketedPaste bool
+
+	// The mouse reporting requested by the program; see EncodeMouse.
+	Mouse         MouseMode
+	MouseEncoding MouseEncoding
+}
+
+// Screen is the saved content and cursor of a screen buffer.
+type Screen struct {
+	Lines    [][]Cell
+	Top      int
+	Row, Col int
 }
 
 func NewTerminal() *Terminal {
@@ -158,6 +319,248 @@ func NewTerminal() *Terminal {
 	}
 }
 
+// Resize changes the terminal dimensions.  Content is not reflowed:
+// on-screen lines wider than the new width are clipped, and the cursor
+// is kept within the new screen area.
+func (t *Terminal) Resize(dirty *TermDirty, width, height int) {
+	if t.Primary != nil {
+		t.Primary.resize(t.Height, width, height)
+	}
+	if height > t.Height && t.CanScroll {
+		// Pull scrollback down into the grown screen, keeping the bottom
+		// of the screen where it was.
+		t.Top -= height - t.Height
+		if t.Top < 0 {
+			t.Top = 0
+		}
+	}
+	t.Width = width
+	t.Height = height
+	t.ScrollTop, t.ScrollBottom = 0, 0
+
+	if t.Row >= t.Top+t.Height {
+		scroll := t.Row - (t.Top + t.Height) + 1
+		if t.CanScroll {
+			t.Top += scroll
+		} else {
+			t.Lines = t.Lines[scroll:]
+			t.Row -= scroll
+		}
+	}
+	if !t.CanScroll && len(t.Lines) > t.Top+t.Height {
+		// Without scrollback, lines below the screen are gone.
+		t.Lines = t.Lines[:t.Top+t.Height]
+	}
+	for row := t.Top; row < len(t.Lines); row++ {
+		if len(t.Lines[row]) > t.Width {
+			t.Lines[row] = t.Lines[row][:t.Width]
+		}
+	}
+	if t.Col > t.Width {
+		t.Col = t.Width
+	}
+	t.fixPosition(dirty)
+	dirty.Lines[-1] = true
+	dirty.Cursor = true
+}
+
+// resize adjusts a saved primary screen for a terminal resize from
+// oldHeight, as Resize does the active screen, so that its cursor is
+// still on screen when it is restored.
+func (s *Screen) resize(oldHeight, width, height int) {
+	if height > oldHeight {
+		s.Top -= height - oldHeight
+		if s.Top < 0 {
+			s.Top = 0
+		}
+	}
+	if s.Row >= s.Top+height {
+		s.Top = s.Row - height + 1
+	}
+	for row := s.Top; row < len(s.Lines); row++ {
+		if len(s.Lines[row]) > width {
+			s.Lines[row] = s.Lines[row][:width]
+		}
+	}
+	if s.Col > width {
+		s.Col = width
+	}
+}
+
+// EncodePaste returns the bytes to send to the program for pasted text.
+// Newlines are sent as carriage returns, as if typed.  In bracketed paste
+// mode the text is wrapped in markers, so the program can tell it apart
+// from typed input.
+func (t *Terminal) EncodePaste(text string) []byte {
+	text = strings.NewReplacer("\r\n", "\r", "\n", "\r").Replace(text)
+	if !t.BracketedPaste {
+		return []byte(text)
+	}
+	// Don't let the text end the paste early.
+	text = strings.Replace(text, pasteEnd, "", -1)
+	return []byte(pasteStart + text + pasteEnd)
+}
+
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// hasScrollRegion reports whether margins narrower than the screen are set.
+func (t *Terminal) hasScrollRegion() bool {
+	return t.ScrollTop > 0 || t.ScrollBottom > 0
+}
+
+// scrollRegion returns the range [top, bottom) of Lines indexes that
+// make up the scrolling region.
+func (t *Terminal) scrollRegion() (top, bottom int) {
+	top = t.Top + t.ScrollTop
+	bottom = t.Top + t.Height
+	if t.ScrollBottom > 0 {
+		bottom = t.Top + t.ScrollBottom
+	}
+	return top, bottom
+}
+
+// scrollUp shifts lines [top, bottom) up by n, discarding lines at the top
+// and leaving blank lines at the bottom.
+func (t *Terminal) scrollUp(dirty *TermDirty, top, bottom, n int) {
+	// Lines past the end of t.Lines are implicitly blank.
+	end := bottom
+	if end > len(t.Lines) {
+		end = len(t.Lines)
+	}
+	if top >= end {
+		return
+	}
+	if n > end-top {
+		n = end - top
+	}
+	copy(t.Lines[top:end-n], t.Lines[top+n:end])
+	for i := end - n; i < end; i++ {
+		t.Lines[i] = make([]Cell, 0)
+	}
+	for i := top; i < end; i++ {
+		dirty.Lines[i] = true
+	}
+}
+
+// scrollDown shifts lines [top, bottom) down by n, discarding lines pushed
+// past the bottom and inserting blank lines at the top.
+func (t *Terminal) scrollDown(dirty *TermDirty, top, bottom, n int) {
+	for i := 0; i < n && len(t.Lines) < bottom; i++ {
+		t.Lines = append(t.Lines, make([]Cell, 0))
+	}
+	end := bottom
+	if end > len(t.Lines) {
+		end = len(t.Lines)
+	}
+	if top >= end {
+		return
+	}
+	if n > end-top {
+		n = end - top
+	}
+	copy(t.Lines[top+n:end], t.Lines[top:end-n])
+	for i := top; i < top+n; i++ {
+		t.Lines[i] = make([]Cell, 0)
+	}
+	for i := top; i < end; i++ {
+		dirty.Lines[i] = true
+	}
+}
+
+// lineFeed moves the cursor down a line, scrolling the scrolling region
+// if the cursor is on its bottom margin.  Below the margins, the cursor
+// stops at the bottom of the screen without scrolling anything.
+func (t *Terminal) lineFeed(dirty *TermDirty) {
+	if t.hasScrollRegion() {
+		top, bottom := t.scrollRegion()
+		if t.Row == bottom-1 {
+			t.scrollUp(dirty, top, bottom, 1)
+			t.fixPosition(dirty)
+			return
+		}
+		if t.Row >= bottom && t.Row == t.Top+t.Height-1 {
+			return
+		}
+	}
+	t.Row++
+	t.fixPosition(dirty)
+}
+
+// TrimScrollback removes lines scrolled off the top of the screen beyond
+// the most recent max, returning the removed lines.  The remaining lines,
+// and any positions and dirty lines referring to them, are renumbered to
+// start at 0.  Only the primary screen has scrollback to trim.
+func (t *Terminal) TrimScrollback(dirty *TermDirty, max int) [][]Cell {
+	n := t.Top - max
+	if n <= 0 || t.Primary != nil {
+		return nil
+	}
+	trimmed := make([][]Cell, n)
+	copy(trimmed, t.Lines[:n])
+	copy(t.Lines, t.Lines[n:])
+	for i := len(t.Lines) - n; i < len(t.Lines); i++ {
+		t.Lines[i] = nil
+	}
+	t.Lines = t.Lines[:len(t.Lines)-n]
+	t.Top -= n
+	t.Row -= n
+	t.SaveRow -= n
+	if t.SaveRow < 0 {
+		t.SaveRow = 0
+	}
+
+	lines := dirty.Lines
+	dirty.Lines = make(map[int]bool, len(lines))
+	for row := range lines {
+		if row < 0 {
+			dirty.Lines[row] = true
+		} else if row >= n {
+			dirty.Lines[row-n] = true
+		}
+	}
+	return trimmed
+}
+
+// AltScreen reports whether the alternate screen buffer is active.
+func (t *Terminal) AltScreen() bool {
+	return t.Primary != nil
+}
+
+// enterAltScreen saves the primary screen and cursor and switches to a
+// blank alternate screen, which has no scrollback.
+func (t *Terminal) enterAltScreen(dirty *TermDirty) {
+	if t.Primary != nil {
+		return
+	}
+	t.Primary = &Screen{Lines: t.Lines, Top: t.Top, Row: t.Row, Col: t.Col}
+	t.Lines = make([][]Cell, t.Height)
+	t.Row -= t.Top
+	t.Top = 0
+	t.CanScroll = false
+	t.fixPosition(dirty)
+	dirty.Lines[-1] = true
+	dirty.Cursor = true
+}
+
+// exitAltScreen discards the alternate screen and restores the primary
+// screen and cursor.
+func (t *Terminal) exitAltScreen(dirty *TermDirty) {
+	if t.Primary == nil {
+		return
+	}
+	p := t.Primary
+	t.Primary = nil
+	t.Lines = p.Lines
+	t.Top, t.Row, t.Col = p.Top, p.Row, p.Col
+	t.CanScroll = true
+	t.fixPosition(dirty)
+	dirty.Lines[-1] = true
+	dirty.Cursor = true
+}
+
 // fixPosition ensures that terminal offsets (Top/Row/Height) always
 // refer to valid places within the Terminal Lines array.
 func (t *Terminal) fixPosition(dirty *TermDirty) {
@@ -175,7 +578,7 @@ func (t *Terminal) fixPosition(dirty *TermDirty) {
 		t.Lines = append(t.Lines, make([]Cell, 0))
 	}
 	for t.Col > len(t.Lines[t.Row]) {
-		t.Lines[t.Row] = append(t.Lines[t.Row], Cell{' ', 0})
+		t.Lines[t.Row] = append(t.Lines[t.Row], Cell{Ch: ' '})
 	}
 }
 
@@ -243,8 +646,7 @@ func (tr *TermReader) Read(r *bufio.Reader) error {
 	case c == '\n':
 		tr.WithTerm(func(t *Terminal) {
 			t.Col = 0
-			t.Row++
-			t.fixPosition(&tr.Dirty)
+			t.lineFeed(&tr.Dirty)
 			tr.Dirty.Cursor = true
 		})
 	case c == '\t':
@@ -272,21 +674,56 @@ func (tr *TermReader) Read(r *bufio.Reader) error {
 			buf[i] = rune(c)
 		}
 		tr.writeRunes(buf[:max], tr.Attr)
-	default:
+	case c >= 0x80:
 		r.UnreadByte()
 		return tr.readUTF8(r)
+	default:
+		tr.TODOs.Add("control character %#x", c)
 	}
 	return nil
 }
 
 func (t *Terminal) writeRune(dirty *TermDirty, r rune, attr Attr) {
-	if t.Col == t.Width {
-		t.Row++
+	width := RuneWidth(r)
+	if width == 0 {
+		t.writeCombining(dirty, r)
+		return
+	}
+	if t.Col+width > t.Width {
 		t.Col = 0
+		t.lineFeed(dirty)
 	}
-	t.Col++
+	t.Col += width
 	t.fixPosition(dirty)
-	t.Lines[t.Row][t.Col-1] = Cell{r, attr}
+	line := t.Lines[t.Row]
+	col := t.Col - width
+	// Overwriting either half of a wide character blanks the other half.
+	if line[col].Ch == 0 && col > 0 {
+		line[col-1] = Cell{Ch: ' ', Attr: line[col-1].Attr}
+	}
+	if end := col + width; end < len(line) && line[end].Ch == 0 {
+		line[end] = Cell{Ch: ' ', Attr: line[end].Attr}
+	}
+	line[col] = Cell{Ch: r, Attr: attr}
+	if width == 2 {
+		line[col+1] = Cell{Attr: attr}
+	}
+	dirty.Lines[t.Row] = true
+}
+
+// writeCombining attaches a zero-width character to the character
+// before the cursor.
+func (t *Terminal) writeCombining(dirty *TermDirty, r rune) {
+	line := t.Lines[t.Row]
+	col := t.Col - 1
+	if col >= 0 && col < len(line) && line[col].Ch == 0 {
+		col-- // second half of a wide character
+	}
+	if col < 0 || col >= len(line) {
+		// Nothing to combine with.
+		return
+	}
+	line[col].Combining += string(r)
 	dirty.Lines[t.Row] = true
 }
 
@@ -299,47 +736,34 @@ func (tr *TermReader) writeRunes(rs []rune, attr Attr) {
 	})
 }
 
-func (t *TermReader) readUTF8(r io.ByteScanner) error {
-	c, err := r.ReadByte()
-	if err != nil {
-		return err
-	}
-
-	attr := t.Attr
-
-	var uc rune
+// readUTF8 reads a block of non-ASCII text.
+func (tr *TermReader) readUTF8(r *bufio.Reader) error {
+	var buf [80]rune
 	n := 0
-	switch {
-	case c&0xE0 == 0xB0:
-		uc = rune(c & 0x1F)
-		n = 2
-	case c&0xF0 == 0xE0:
-		uc = rune(c & 0x0F)
-		n = 3
-	default:
-		if c&0xF0 == 0xF0 {
-			log.Printf("term: not yet implemented: utf8 start %#v", c)
+	for n < len(buf) {
+		peek, _ := r.Peek(r.Buffered())
+		if n > 0 && !utf8.FullRune(peek) {
+			// Stop rather than block on the rest of a character that
+			// hasn't arrived yet.
+			break
 		}
-		attr.SetInverse(true)
-		t.writeRunes([]rune{'@'}, attr)
-		return nil
-	}
-
-	for i := 1; i < n; i++ {
-		c, err := r.ReadByte()
+		if len(peek) > 0 && (peek[0] < ' ' || peek[0] == 0x7f) {
+			break
+		}
+		c, _, err := r.ReadRune()
 		if err != nil {
 			return err
 		}
-		if c&0xC0 != 0x80 {
-			log.Printf("term: not yet implemented: utf8 continuation %#v", c)
-			attr.SetInverse(true)
-			uc = '@'
-			break
+		if c >= 0x80 && c < 0xa0 {
+			tr.TODOs.Add("C1 control %#x", c)
+			continue
 		}
-		uc = uc<<6 | rune(c&0x3F)
+		// Invalid input decodes as utf8.RuneError, the replacement
+		// character, which is what we want to show.
+		buf[n] = c
+		n++
 	}
-	// TODO: read block of UTF here.
-	t.writeRunes([]rune{uc}, attr)
+	tr.writeRunes(buf[:n], tr.Attr)
 	return nil
 }
 
@@ -361,10 +785,14 @@ func (tr *TermReader) readEscape(r io.ByteScanner) error {
 		default:
 			tr.TODOs.Add("g0 charset %s", showChar(c))
 		}
-	case c == '=':
-		tr.TODOs.Add("application keypad")
-	case c == '>':
-		tr.TODOs.Add("normal keypad")
+	case c == '=': // application keypad (DECKPAM)
+		tr.WithTerm(func(t *Terminal) {
+			t.AppKeypad = true
+		})
+	case c == '>': // normal keypad (DECKPNM)
+		tr.WithTerm(func(t *Terminal) {
+			t.AppKeypad = false
+		})
 	case c == '[':
 		return tr.readCSI(r)
 	case c == ']':
@@ -398,6 +826,16 @@ func (tr *TermReader) readEscape(r io.ByteScanner) error {
 		}
 	case c == 'M': // move up/insert line
 		tr.WithTerm(func(t *Terminal) {
+			if t.hasScrollRegion() {
+				top, bottom := t.scrollRegion()
+				if t.Row == top {
+					t.scrollDown(&tr.Dirty, top, bottom, 1)
+				} else if t.Row > t.Top {
+					t.Row--
+					tr.Dirty.Cursor = true
+				}
+				return
+			}
 			if t.Row == 0 {
 				// Insert line above.
 				if t.CanScroll {
@@ -457,11 +895,77 @@ func mapColor(color int, arg int) int {
 	}
 }
 
+// setPrivateMode handles a DEC private mode set (DECSET) or reset (DECRST).
+// Called within WithTerm.
+func (tr *TermReader) setPrivateMode(t *Terminal, mode int, set bool) {
+	switch mode {
+	case 1: // application cursor keys (DECCKM)
+		t.AppCursorKeys = set
+	case 7: // wraparound mode
+		tr.TODOs.Add("wraparound mode")
+	case 12: // blinking cursor
+		// Ignore; this appears in cnorm/cvvis as a way to adjust the
+		// "very visible cursor" state.
+	case 25: // show cursor
+		t.HideCursor = !set
+		tr.Dirty.Cursor = true
+	case 9, 1000, 1002, 1003, 1006, 1015: // mouse reporting
+		t.setMouseMode(mode, set)
+	case 1001: // highlight mouse tracking
+		tr.TODOs.Add("highlight mouse tracking")
+	case 1005: // UTF-8 mouse encoding
+		tr.TODOs.Add("utf-8 mouse encoding")
+	case 1049: // alternate screen buffer, saving the cursor
+		if set {
+			t.enterAltScreen(&tr.Dirty)
+		} else {
+			t.exitAltScreen(&tr.Dirty)
+		}
+	case 2004: // bracketed paste
+		t.BracketedPaste = set
+	default:
+		log.Printf("term: unknown dec private mode %v %v", mode, set)
+	}
+}
+
+// readExtendedColor parses the arguments following a 38 or 48 SGR code,
+// either "5;n" for a palette color or "2;r;g;b" for a direct color.
+// It returns the encoded color (or -1 if invalid) and the number of
+// arguments consumed.
+func readExtendedColor(args []int) (int, int) {
+	if len(args) == 0 {
+		return -1, 0
+	}
+	switch args[0] {
+	case 5:
+		if len(args) < 2 || args[1] > 255 {
+			return -1, len(args)
+		}
+		return IndexedColor(args[1]), 2
+	case 2:
+		if len(args) < 4 {
+			return -1, len(args)
+		}
+		r, g, b := args[1], args[2], args[3]
+		if r > 255 || g > 255 || b > 255 {
+			return -1, 4
+		}
+		return RGBColor(r, g, b), 4
+	default:
+		return -1, 1
+	}
+}
+
 // readCSI reads a CSI escape, which look like
-//   \e[1;2x
+//
+//	\e[1;2x
+//
 // where "1" and "2" are "arguments" to the "x" command.
 func (tr *TermReader) readCSI(r io.ByteScanner) error {
 	var args []int
+	// subArgs holds colon-separated subparameters, as in "\e[4:3m",
+	// keyed by the index of the argument they follow.
+	var subArgs map[int][]int
 
 	qflag := false
 	gtflag := false
@@ -484,6 +988,20 @@ L:
 		if err != nil {
 			return err
 		}
+		for c == ':' {
+			n, err := tr.readInt(r)
+			if err != nil {
+				return err
+			}
+			if subArgs == nil {
+				subArgs = map[int][]int{}
+			}
+			subArgs[len(args)-1] = append(subArgs[len(args)-1], n)
+			c, err = r.ReadByte()
+			if err != nil {
+				return err
+			}
+		}
 		if c == ';' {
 			goto L
 		}
@@ -515,7 +1033,7 @@ L:
 			}
 			copy(t.Lines[t.Row][t.Col+n:], t.Lines[t.Row][t.Col:])
 			for i := 0; i < n; i++ {
-				t.Lines[t.Row][t.Col+i] = Cell{' ', 0}
+				t.Lines[t.Row][t.Col+i] = Cell{Ch: ' '}
 			}
 			tr.Dirty.Lines[t.Row] = true
 		})
@@ -601,7 +1119,7 @@ L:
 				t.Lines[t.Row] = t.Lines[t.Row][:t.Col]
 			case 1:
 				for i := 0; i < t.Col; i++ {
-					t.Lines[t.Row][i] = Cell{' ', 0}
+					t.Lines[t.Row][i] = Cell{Ch: ' '}
 				}
 			case 2:
 				t.Lines[t.Row] = t.Lines[t.Row][0:0]
@@ -614,14 +1132,22 @@ L:
 		n := 1
 		readArgs(args, &n)
 		tr.WithTerm(func(t *Terminal) {
-			for i := 0; i < n; i++ {
-				t.Lines = append(t.Lines, nil)
+			top, bottom := t.scrollRegion()
+			if t.Row < top || t.Row >= bottom {
+				return // Outside the margins, no effect.
 			}
-			copy(t.Lines[t.Row+n:], t.Lines[t.Row:])
-			for i := 0; i < n; i++ {
-				t.Lines[t.Row+i] = make([]Cell, 0)
+			t.scrollDown(&tr.Dirty, t.Row, bottom, n)
+		})
+	case c == 'M': // delete lines
+		n := 1
+		readArgs(args, &n)
+		tr.WithTerm(func(t *Terminal) {
+			top, bottom := t.scrollRegion()
+			if t.Row < top || t.Row >= bottom {
+				return // Outside the margins, no effect.
 			}
-			tr.Dirty.Lines[-1] = true
+			t.scrollUp(&tr.Dirty, t.Row, bottom, n)
+			t.fixPosition(&tr.Dirty)
 		})
 	case c == 'P': // erase in line
 		arg := 1
@@ -671,68 +1197,92 @@ L:
 		}
 	case qflag && (c == 'h' || c == 'l'): // DEC private mode set/reset
 		set := c == 'h'
-		arg := 0
-		readArgs(args, &arg)
 		tr.WithTerm(func(t *Terminal) {
-			switch arg {
-			case 1:
-				tr.TODOs.Add("application cursor keys mode")
-			case 7: // wraparound mode
-				tr.TODOs.Add("wraparound mode")
-			case 12: // blinking cursor
-				// Ignore; this appears in cnorm/cvvis as a way to adjust the
-				// "very visible cursor" state.
-			case 25: // show cursor
-				t.HideCursor = !set
-				tr.Dirty.Cursor = true
-			case 1000, 1001, 1002: // mouse
-				tr.TODOs.Add("mouse handling")
-			case 1049: // alternate screen buffer
-				// Rather than implementing an alternate screen, instead just clobber the content.
-				// This is because we don't anticipate running a series of programs within a given term,
-				// but rather just one.
-				t.Lines = make([][]Cell, t.Height)
-				t.Top = 0
-				t.CanScroll = false
-				tr.Dirty.Lines[-1] = true
-			case 2004: // bracketed paste
-				tr.TODOs.Add("bracketed paste")
-			default:
-				log.Printf("term: unknown dec private mode %v %v", args, set)
+			for _, arg := range args {
+				tr.setPrivateMode(t, arg, set)
 			}
 		})
 	case c == 'm': // character attributes
 		if len(args) == 0 {
 			args = append(args, 0)
 		}
-		for _, arg := range args {
+		for i := 0; i < len(args); i++ {
+			arg := args[i]
 			switch {
 			case arg == 0:
 				tr.Attr = 0
 			case arg == 1:
 				tr.Attr.SetBright(true)
-			case arg == 2: // faint
-				// ignore
+			case arg == 2:
+				tr.Attr.SetFaint(true)
+			case arg == 3:
+				tr.Attr.SetItalic(true)
+			case arg == 4:
+				u := UnderlineSingle
+				if sub, ok := subArgs[i]; ok {
+					// "4:x" selects a style, with 4:0 meaning none.
+					u = Underline(sub[0])
+					if u > UnderlineDashed {
+						log.Printf("term: bad underline style %d", u)
+						break
+					}
+				}
+				tr.Attr.SetUnderline(u)
+			case arg == 5 || arg == 6: // slow or rapid blink
+				tr.Attr.SetBlink(true)
 			case arg == 7:
 				tr.Attr.SetInverse(true)
-			case arg == 22: // clear bold
+			case arg == 8:
+				tr.Attr.SetHidden(true)
+			case arg == 9:
+				tr.Attr.SetStrikethrough(true)
+			case arg == 21:
+				tr.Attr.SetUnderline(UnderlineDouble)
+			case arg == 22: // normal intensity
 				tr.Attr.SetBright(false)
-			case arg == 23: // clear italics
-				// ignore
-			case arg == 29: // clear crossed-out
-				// ignore
+				tr.Attr.SetFaint(false)
+			case arg == 23:
+				tr.Attr.SetItalic(false)
+			case arg == 24:
+				tr.Attr.SetUnderline(UnderlineNone)
+			case arg == 25:
+				tr.Attr.SetBlink(false)
 			case arg == 27:
 				tr.Attr.SetInverse(false)
+			case arg == 28:
+				tr.Attr.SetHidden(false)
+			case arg == 29:
+				tr.Attr.SetStrikethrough(false)
+			case arg == 38 || arg == 48:
+				var color, n int
+				if sub, ok := subArgs[i]; ok {
+					// "38:2:cs:r:g:b" carries a color space id, which
+					// some programs omit.
+					if len(sub) >= 5 && sub[0] == 2 {
+						sub = append([]int{2}, sub[2:]...)
+					}
+					color, _ = readExtendedColor(sub)
+				} else {
+					color, n = readExtendedColor(args[i+1:])
+					i += n
+				}
+				if color < 0 {
+					log.Printf("term: bad extended color %v", args)
+					break
+				}
+				if arg == 38 {
+					tr.Attr.SetColor(color)
+				} else {
+					tr.Attr.SetBackColor(color)
+				}
 			case arg >= 30 && arg < 40:
 				tr.Attr.SetColor(mapColor(arg-30, arg))
 			case arg >= 40 && arg < 50:
 				tr.Attr.SetBackColor(mapColor(arg-40, arg))
 			case arg >= 90 && arg <= 97:
-				tr.Attr.SetBright(true)
-				tr.Attr.SetColor(mapColor(arg-90, arg))
+				tr.Attr.SetColor(IndexedColor(arg - 90 + 8))
 			case arg >= 100 && arg <= 107:
-				// TODO: set bright background?
-				tr.Attr.SetBackColor(mapColor(arg-100, arg))
+				tr.Attr.SetBackColor(IndexedColor(arg - 100 + 8))
 			default:
 				log.Printf("term: unknown color %v", args)
 			}
@@ -769,15 +1319,53 @@ L:
 		default:
 			log.Printf("term: unknown status report arg %v", args)
 		}
+	case c == 'S': // scroll up
+		n := 1
+		readArgs(args, &n)
+		tr.WithTerm(func(t *Terminal) {
+			if !t.hasScrollRegion() && t.CanScroll {
+				// Scroll the whole screen, moving lines into the scrollback.
+				t.Top += n
+				t.Row += n
+				t.fixPosition(&tr.Dirty)
+				tr.Dirty.Lines[t.Row] = true
+				tr.Dirty.Cursor = true
+				return
+			}
+			top, bottom := t.scrollRegion()
+			t.scrollUp(&tr.Dirty, top, bottom, n)
+		})
+	case c == 'T' && len(args) <= 1: // scroll down
+		n := 1
+		readArgs(args, &n)
+		tr.WithTerm(func(t *Terminal) {
+			top, bottom := t.scrollRegion()
+			t.scrollDown(&tr.Dirty, top, bottom, n)
+		})
 	case c == 'r': // set scrolling region
-		top, bot := 1, 1
-		readArgs(args, &top, &bot)
 		tr.WithTerm(func(t *Terminal) {
+			top, bot := 1, t.Height
+			readArgs(args, &top, &bot)
+			if top == 0 {
+				top = 1
+			}
+			if bot == 0 || bot > t.Height {
+				bot = t.Height
+			}
+			if top >= bot {
+				log.Printf("term: bad scrolling region %v", args)
+				return
+			}
 			if top == 1 && bot == t.Height {
-				// Just setting the current region as scroll.
+				t.ScrollTop, t.ScrollBottom = 0, 0
 			} else {
-				tr.TODOs.Add("set scrolling region %v", args)
+				t.ScrollTop, t.ScrollBottom = top-1, bot
 			}
+			// Setting the region homes the cursor.
+			t.Row = t.Top
+			t.Col = 0
+			t.fixPosition(&tr.Dirty)
+			tr.Dirty.Cursor = true
 		})
 	case c == 't': // window manipulation
 		cmd := 0
@@ -890,15 +1478,13 @@ func (t *TermReader) DisplayString(input string) {
 
 // ToString renders the terminal state to a simple string, for use in tests.
 func (t *Terminal) ToString() string {
-	var buf [6]byte
 	str := ""
 	for _, l := range t.Lines {
 		if str != "" {
 			str += "\n"
 		}
 		for _, c := range l {
-			n := utf8.EncodeRune(buf[:], c.Ch)
-			str += string(buf[:n])
+			str += c.Text()
 		}
 	}
 	return str
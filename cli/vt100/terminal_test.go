@@ -91,6 +91,80 @@ func TestColor(t *testing.T) {
 	assert.Equal(t, Attr(0), tr.Attr)
 }
 
+func TestBrightColors(t *testing.T) {
+	_, tr := newTestTerminal()
+	mustRun(t, tr, "\x1b[94;101m")
+	assert.Equal(t, false, tr.Attr.Bright())
+	assert.Equal(t, IndexedColor(12), tr.Attr.Color())
+	assert.Equal(t, IndexedColor(9), tr.Attr.BackColor())
+}
+
+func TestExtendedColors(t *testing.T) {
+	_, tr := newTestTerminal()
+	mustRun(t, tr, "\x1b[38;5;208m")
+	assert.Equal(t, IndexedColor(208), tr.Attr.Color())
+	assert.Equal(t, 0, tr.Attr.BackColor())
+
+	mustRun(t, tr, "\x1b[1;48;2;10;20;30;4m")
+	assert.Equal(t, RGBColor(10, 20, 30), tr.Attr.BackColor())
+	assert.Equal(t, 0x0a141e|ColorRGB, tr.Attr.BackColor())
+	assert.Equal(t, IndexedColor(208), tr.Attr.Color())
+	assert.Equal(t, true, tr.Attr.Bright())
+	assert.Nil(t, tr.Attr.Validate())
+
+	mustRun(t, tr, "\x1b[38;2;255;255;255m")
+	assert.Equal(t, RGBColor(255, 255, 255), tr.Attr.Color())
+	assert.Equal(t, "Attr{bright,underline,fg:#ffffff,bg:#0a141e}", tr.Attr.String())
+
+	mustRun(t, tr, "\x1b[39;49m")
+	assert.Equal(t, 0, tr.Attr.Color())
+	assert.Equal(t, 0, tr.Attr.BackColor())
+
+	// Bad or truncated extended colors are ignored.
+	mustRun(t, tr, "\x1b[38;5;300m\x1b[48;2;1m")
+	assert.Equal(t, 0, tr.Attr.Color())
+	assert.Equal(t, 0, tr.Attr.BackColor())
+}
+
+func TestExtendedColorsColon(t *testing.T) {
+	_, tr := newTestTerminal()
+	mustRun(t, tr, "\x1b[38:5:208;48:2::1:2:3m")
+	assert.Equal(t, IndexedColor(208), tr.Attr.Color())
+	assert.Equal(t, RGBColor(1, 2, 3), tr.Attr.BackColor())
+	mustRun(t, tr, "\x1b[38:2:4:5:6m")
+	assert.Equal(t, RGBColor(4, 5, 6), tr.Attr.Color())
+}
+
+func TestTextAttributes(t *testing.T) {
+	_, tr := newTestTerminal()
+	mustRun(t, tr, "\x1b[1;2;3;4;5;7;8;9m")
+	assert.Equal(t, "Attr{inverse,bright,faint,italic,underline,blink,hidden,strikethrough}", tr.Attr.String())
+	assert.Nil(t, tr.Attr.Validate())
+	assert.Equal(t, 0x3FF&^(0x6<<4), tr.Attr.Flags())
+
+	mustRun(t, tr, "\x1b[22m")
+	assert.False(t, tr.Attr.Bright())
+	assert.False(t, tr.Attr.Faint())
+	mustRun(t, tr, "\x1b[23;24;25;27;28;29m")
+	assert.Equal(t, Attr(0), tr.Attr)
+
+	mustRun(t, tr, "\x1b[21m")
+	assert.Equal(t, UnderlineDouble, tr.Attr.Underline())
+	mustRun(t, tr, "\x1b[4:3m")
+	assert.Equal(t, UnderlineCurly, tr.Attr.Underline())
+	assert.Equal(t, int(UnderlineCurly)<<4, tr.Attr.Flags())
+	mustRun(t, tr, "\x1b[4:9m")
+	assert.Equal(t, UnderlineCurly, tr.Attr.Underline())
+	mustRun(t, tr, "\x1b[4:0m")
+	assert.Equal(t, UnderlineNone, tr.Attr.Underline())
+
+	// Attributes don't disturb colors.
+	mustRun(t, tr, "\x1b[38;5;100;4:5m")
+	assert.Equal(t, IndexedColor(100), tr.Attr.Color())
+	assert.Equal(t, UnderlineDashed, tr.Attr.Underline())
+	assert.Nil(t, tr.Attr.Validate())
+}
+
 func TestBackspace(t *testing.T) {
 	term, tr := newTestTerminal()
 	mustRun(t, tr, "\x08")
@@ -153,11 +227,148 @@ func TestPrivateModes(t *testing.T) {
 	assert.Equal(t, "", term.ToString())
 }
 
+func TestAltScreen(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 3
+	mustRun(t, tr, "a\nb\nc\n$ ")
+	assert.Equal(t, 1, term.Top)
+	assertPos(t, term, 3, 2)
+
+	mustRun(t, tr, "\x1b[?1049h")
+	assert.True(t, term.AltScreen())
+	assert.False(t, term.CanScroll)
+	assert.Equal(t, 0, term.Top)
+	assertPos(t, term, 2, 2)
+	mustRun(t, tr, "\x1b[Hfull\nscreen")
+	assert.Equal(t, "full\nscreen\n  ", term.ToString())
+
+	mustRun(t, tr, "\x1b[?1049l")
+	assert.False(t, term.AltScreen())
+	assert.True(t, term.CanScroll)
+	assert.Equal(t, "a\nb\nc\n$ ", term.ToString())
+	assert.Equal(t, 1, term.Top)
+	assertPos(t, term, 3, 2)
+	assert.True(t, tr.Dirty.Lines[-1])
+
+	// Resetting again is harmless.
+	mustRun(t, tr, "\x1b[?1049l")
+	assert.Equal(t, "a\nb\nc\n$ ", term.ToString())
+}
+
+func TestTrimScrollback(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 3
+	mustRun(t, tr, "1\n2\n3\n4\n5\n6\x1b7\n7\n$ ")
+	assert.Equal(t, 5, term.Top)
+	tr.Dirty.Reset()
+	mustRun(t, tr, "x\x1b[A.")
+
+	// Nothing to trim when within the limit.
+	assert.Nil(t, term.TrimScrollback(&tr.Dirty, 5))
+
+	trimmed := term.TrimScrollback(&tr.Dirty, 1)
+	assert.Equal(t, 4, len(trimmed))
+	assert.Equal(t, "1", trimmed[0][0].Text())
+	assert.Equal(t, "5\n6\n7  .\n$ x", term.ToString())
+	assert.Equal(t, 1, term.Top)
+	assertPos(t, term, 2, 4)
+	assert.Equal(t, map[int]bool{2: true, 3: true}, tr.Dirty.Lines)
+
+	// Restoring the saved position finds the same line.
+	mustRun(t, tr, "\x1b8!")
+	assert.Equal(t, "5\n6!\n7  .\n$ x", term.ToString())
+
+	// The alternate screen has no scrollback.
+	mustRun(t, tr, "\x1b[?1049h")
+	assert.Nil(t, term.TrimScrollback(&tr.Dirty, 0))
+}
+
 func TestScrollingRegion(t *testing.T) {
 	term, tr := newTestTerminal()
 	mustRun(t, tr, "\x1b[1;24r")
-	// ignored
+	// Full screen region is the same as no region.
 	assert.Equal(t, "", term.ToString())
+	assert.Equal(t, 0, term.ScrollTop)
+	assert.Equal(t, 0, term.ScrollBottom)
+
+	mustRun(t, tr, "\x1b[2;3r")
+	assert.Equal(t, 1, term.ScrollTop)
+	assert.Equal(t, 3, term.ScrollBottom)
+	assertPos(t, term, 0, 0)
+
+	mustRun(t, tr, "\x1b[r")
+	assert.Equal(t, 0, term.ScrollTop)
+	assert.Equal(t, 0, term.ScrollBottom)
+}
+
+func TestScrollingRegionLineFeed(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 4
+	term.CanScroll = false
+	mustRun(t, tr, "head\nb\nc\nstatus")
+	mustRun(t, tr, "\x1b[2;3r") // rows 2-3 scroll, 1 and 4 are fixed
+	mustRun(t, tr, "\x1b[3;1H") // bottom margin
+	mustRun(t, tr, "\nd")
+	assert.Equal(t, "head\nc\nd\nstatus", term.ToString())
+	assertPos(t, term, 2, 1)
+	mustRun(t, tr, "\ne")
+	assert.Equal(t, "head\nd\ne\nstatus", term.ToString())
+
+	// Below the margins, the cursor stops at the bottom of the screen.
+	mustRun(t, tr, "\x1b[4;1H\n\nS")
+	assert.Equal(t, "head\nd\ne\nStatus", term.ToString())
+	assertPos(t, term, 3, 1)
+	assert.Equal(t, 0, term.Top)
+}
+
+func TestScrollingRegionReverseIndex(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 4
+	term.CanScroll = false
+	mustRun(t, tr, "head\nb\nc\nstatus")
+	mustRun(t, tr, "\x1b[2;3r\x1b[2;1H")
+	mustRun(t, tr, "\x1bMx")
+	assert.Equal(t, "head\nx\nb\nstatus", term.ToString())
+	assertPos(t, term, 1, 1)
+}
+
+func TestScrollingRegionInsertDeleteLines(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 5
+	term.CanScroll = false
+	mustRun(t, tr, "head\nb\nc\nd\nstatus")
+	mustRun(t, tr, "\x1b[2;4r\x1b[3;1H")
+	mustRun(t, tr, "\x1b[L")
+	assert.Equal(t, "head\nb\n\nc\nstatus", term.ToString())
+	mustRun(t, tr, "\x1b[2M")
+	assert.Equal(t, "head\nb\n\n\nstatus", term.ToString())
+
+	// Outside the margins, insert has no effect.
+	mustRun(t, tr, "\x1b[1;1H\x1b[L")
+	assert.Equal(t, "head\nb\n\n\nstatus", term.ToString())
+}
+
+func TestScrollUpDown(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 4
+	term.CanScroll = false
+	mustRun(t, tr, "a\nb\nc\nd")
+	mustRun(t, tr, "\x1b[2;4r")
+	mustRun(t, tr, "\x1b[S")
+	assert.Equal(t, "a\nc\nd\n", term.ToString())
+	mustRun(t, tr, "\x1b[2T")
+	assert.Equal(t, "a\n\n\nc", term.ToString())
+}
+
+func TestScrollUpScrollback(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Height = 3
+	mustRun(t, tr, "a\nb")
+	mustRun(t, tr, "\x1b[2S")
+	// Lines scroll into the scrollback rather than being lost.
+	assert.Equal(t, 2, term.Top)
+	assertPos(t, term, 3, 1)
+	assert.Equal(t, "a\nb\n\n ", term.ToString())
 }
 
 func TestResetMode(t *testing.T) {
@@ -237,6 +448,110 @@ func TestUTF8(t *testing.T) {
 	assert.Equal(t, rune(0x25bd), term.Lines[0][0].Ch)
 }
 
+func TestUTF8Lengths(t *testing.T) {
+	term, tr := newTestTerminal()
+	mustRun(t, tr, "a\u00e9\u25bd\U0001d11ez")
+	assert.Equal(t, "a\u00e9\u25bd\U0001d11ez", term.ToString())
+	assertPos(t, term, 0, 5)
+}
+
+func TestUTF8Split(t *testing.T) {
+	// A character split across reads is decoded once the rest arrives.
+	term, tr := newTestTerminal()
+	r := bufio.NewReader(io.MultiReader(
+		strings.NewReader("x\xe2\x96"),
+		strings.NewReader("\xbdy"),
+	))
+	var err error
+	for err == nil {
+		err = tr.Read(r)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "x\u25bdy", term.ToString())
+}
+
+func TestWideChars(t *testing.T) {
+	term, tr := newTestTerminal()
+	mustRun(t, tr, "\u4e2d\u6587!")
+	assert.Equal(t, "\u4e2d\u6587!", term.ToString())
+	assertPos(t, term, 0, 5)
+	assert.Equal(t, 5, len(term.Lines[0]))
+	assert.Equal(t, rune(0), term.Lines[0][1].Ch)
+
+	// Emoji outside the BMP are wide too.
+	mustRun(t, tr, "\r\U0001f600x")
+	assert.Equal(t, "\U0001f600x !", term.ToString())
+	assertPos(t, term, 0, 3)
+}
+
+func TestWideCharsOverwrite(t *testing.T) {
+	term, tr := newTestTerminal()
+	mustRun(t, tr, "\u4e2d\u6587")
+	// Overwriting the second half of a wide char blanks the first half.
+	mustRun(t, tr, "\x1b[2Gx")
+	assert.Equal(t, " x\u6587", term.ToString())
+	// Overwriting the first half blanks the second half.
+	mustRun(t, tr, "y")
+	assert.Equal(t, " xy ", term.ToString())
+}
+
+func TestWideCharsWrap(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Width = 5
+	mustRun(t, tr, "abcd\u4e2d")
+	assert.Equal(t, "abcd\n\u4e2d", term.ToString())
+	assertPos(t, term, 1, 2)
+}
+
+func TestCombiningChars(t *testing.T) {
+	term, tr := newTestTerminal()
+	mustRun(t, tr, "e\u0301x")
+	assert.Equal(t, "e\u0301x", term.ToString())
+	assertPos(t, term, 0, 2)
+	assert.Equal(t, "\u0301", term.Lines[0][0].Combining)
+
+	// Combining with a wide char attaches to its first half.
+	mustRun(t, tr, "\u4e2d\u200d")
+	assert.Equal(t, "\u200d", term.Lines[0][2].Combining)
+
+	var text strings.Builder
+	for _, cell := range term.Lines[0] {
+		cell.WriteText(&text)
+	}
+	assert.Equal(t, "e\u0301x\u4e2d\u200d", text.String())
+
+	// With nothing to combine with, it's dropped.
+	term, tr = newTestTerminal()
+	mustRun(t, tr, "\u0301")
+	assert.Equal(t, "", term.ToString())
+}
+
+func TestKeyModes(t *testing.T) {
+	term, tr := newTestTerminal()
+	assert.False(t, term.AppCursorKeys)
+	assert.False(t, term.AppKeypad)
+	// As sent by tput smkx under xterm.
+	mustRun(t, tr, "\x1b[?1h\x1b=")
+	assert.True(t, term.AppCursorKeys)
+	assert.True(t, term.AppKeypad)
+	// tput rmkx.
+	mustRun(t, tr, "\x1b[?1l\x1b>")
+	assert.False(t, term.AppCursorKeys)
+	assert.False(t, term.AppKeypad)
+}
+
+func TestBracketedPaste(t *testing.T) {
+	term, tr := newTestTerminal()
+	assert.Equal(t, "a\rb\rc", string(term.EncodePaste("a\nb\r\nc")))
+	mustRun(t, tr, "\x1b[?2004h")
+	assert.True(t, term.BracketedPaste)
+	assert.Equal(t, "\x1b[200~a\rb\x1b[201~", string(term.EncodePaste("a\nb")))
+	// An embedded end marker can't escape the paste.
+	assert.Equal(t, "\x1b[200~xecho hi\x1b[201~", string(term.EncodePaste("x\x1b[201~echo hi")))
+	mustRun(t, tr, "\x1b[?2004l")
+	assert.False(t, term.BracketedPaste)
+}
+
 func TestStatusReport(t *testing.T) {
 	term, tr := newTestTerminal()
 	buf := &bytes.Buffer{}
@@ -295,11 +610,10 @@ func TestInsertLine(t *testing.T) {
 
 func TestBinary(t *testing.T) {
 	term, tr := newTestTerminal()
-	// Don't choke on non-UTF8 inputs.
-	// TODO: maybe render them with some special character to represent
-	// mojibake.
+	// Don't choke on non-UTF8 inputs; render them with the replacement
+	// character.
 	mustRun(t, tr, "\xc8\x00\x64\x00")
-	assert.Equal(t, "@@d@", term.ToString())
+	assert.Equal(t, "\ufffdd", term.ToString())
 }
 
 func TestAllColors(t *testing.T) {
@@ -324,3 +638,81 @@ func TestNoScrollback(t *testing.T) {
 	assert.Equal(t, term.Top, 0)
 	assert.Equal(t, "b\nc\n", term.ToString())
 }
+
+func TestResize(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.Width = 10
+	mustRun(t, tr, "0123456789\nab")
+	term.Resize(&tr.Dirty, 5, 24)
+	assert.Equal(t, 5, term.Width)
+	assert.Equal(t, "01234\nab", term.ToString())
+	assertPos(t, term, 1, 2)
+	assert.True(t, tr.Dirty.Lines[-1])
+
+	// Subsequent output wraps at the new width.
+	mustRun(t, tr, "cdefg")
+	assert.Equal(t, "01234\nabcde\nfg", term.ToString())
+}
+
+func TestResizeShorter(t *testing.T) {
+	term, tr := newTestTerminal()
+	mustRun(t, tr, "a\nb\nc\nd")
+	term.Resize(&tr.Dirty, 80, 2)
+	// The cursor row stays on screen, pushing lines into the scrollback.
+	assert.Equal(t, 2, term.Top)
+	assertPos(t, term, 3, 1)
+	assert.Equal(t, "a\nb\nc\nd", term.ToString())
+
+	term.Resize(&tr.Dirty, 80, 3)
+	assert.Equal(t, 1, term.Top)
+}
+
+func TestResizeNoScrollback(t *testing.T) {
+	term, tr := newTestTerminal()
+	term.CanScroll = false
+	mustRun(t, tr, "a\nb\nc\nd")
+	term.Resize(&tr.Dirty, 80, 2)
+	assert.Equal(t, 0, term.Top)
+	assertPos(t, term, 1, 1)
+	assert.Equal(t, "c\nd", term.ToString())
+}
+
+func TestResizeAltScreen(t *testing.T) {
+	term, tr := newTestTerminal()
+	for i := 0; i < 30; i++ {
+		mustRun(t, tr, fmt.Sprintf("%d\n", i))
+	}
+	assert.Equal(t, 7, term.Top)
+	assertPos(t, term, 30, 0)
+
+	// Shrink while the alternate screen is active, as when resizing the
+	// window with a full-screen program open.
+	mustRun(t, tr, "\x1b[?1049h\x1b[H")
+	term.Resize(&tr.Dirty, 80, 10)
+	// With the cursor at the top, the alternate screen loses its bottom.
+	assert.Equal(t, 10, len(term.Lines))
+	assertPos(t, term, 0, 0)
+	mustRun(t, tr, "\x1b[?1049l")
+	assert.Equal(t, 21, term.Top)
+	assertPos(t, term, 30, 0)
+	mustRun(t, tr, "\x1b[Hx")
+	assertPos(t, term, 21, 1)
+
+	// Growing the alternate screen adds no lines, as it has no
+	// scrollback to pull down.
+	mustRun(t, tr, "\x1b[?1049h")
+	mustRun(t, tr, "\x1b[10;1Hbottom")
+	term.Resize(&tr.Dirty, 80, 24)
+	assert.Equal(t, 10, len(term.Lines))
+	// Shrinking with the cursor at the bottom drops lines from the top.
+	term.Resize(&tr.Dirty, 80, 6)
+	assert.Equal(t, 6, len(term.Lines))
+	assertPos(t, term, 5, 6)
+	assert.Equal(t, 'b', term.Lines[5][0].Ch)
+	term.Resize(&tr.Dirty, 80, 24)
+	mustRun(t, tr, "\x1b[?1049l")
+	// Shrinking to 6 rows left 16 lines above the screen, all of which
+	// growing to 24 pulls back down.
+	assert.Equal(t, 0, term.Top)
+	assertPos(t, term, 21, 1)
+}
@@ -1,5 +1,6 @@
 import * as ts from 'typescript';
 import * as fs from 'fs';
+import * as crypto from 'crypto';
 
 class UnhandledError extends Error {
   constructor(readonly diag: ts.Diagnostic) {
@@ -25,17 +26,35 @@ function cap(name: string) {
   return name.substring(0, 1).toUpperCase() + name.substring(1);
 }
 
+/** Types with builtin readers and writers in every language. */
+const primitives = ['boolean', 'uint8', 'int', 'sint', 'string', 'bytes'];
+
+/**
+ * Returns a hash identifying the parsed schema, which ignores comments and
+ * formatting.  Peers built from schemas with different hashes can't read
+ * each other's messages.
+ */
+function schemaHash(decls: proto.Named[]): string {
+  const hash = crypto.createHash('sha256');
+  hash.update(JSON.stringify(decls));
+  return hash.digest('hex').substring(0, 16);
+}
+
 function genGo(decls: proto.Named[], write: (out: string) => void) {
   const unions = new Set<string>();
 
   write(`package proto
 
 import (
+  "bufio"
+  "bytes"
   "fmt"
   "io"
-  "bufio"
 )
 
+// SchemaHash identifies the schema this code was generated from.
+const SchemaHash = "${schemaHash(decls)}"
+
 type Msg interface{
   Write(w io.Writer) error
   Read(r *bufio.Reader) error
@@ -51,25 +70,73 @@ func ReadUint8(r *bufio.Reader) (byte, error) {
   return r.ReadByte()
 }
 
-func ReadInt(r *bufio.Reader) (int, error) {
-  shift := 0
-  var val int
-  for {
+// maxVarintLen is the longest a varint can be, enough for 64 bits.
+const maxVarintLen = 10
+
+// maxPrealloc bounds how much ReadBytes allocates up front.  Past that,
+// the buffer grows as the data arrives, so that a bogus length can't make
+// us allocate more memory than the input holds.
+const maxPrealloc = 64 << 10
+
+func readUvarint(r *bufio.Reader) (uint64, error) {
+  var val uint64
+  for i := 0; i < maxVarintLen; i++ {
     b, err := r.ReadByte()
     if err != nil { return 0, err }
-    val |= int(b & 0b0111_1111) << shift
+    if i == maxVarintLen-1 && b > 1 {
+      break
+    }
+    val |= uint64(b & 0b0111_1111) << (7 * i)
     if (b & 0b1000_0000) == 0 {
       return val, nil
     }
-    shift = shift + 7
   }
+  return 0, fmt.Errorf("varint overflows 64 bits")
 }
 
-func ReadString(r *bufio.Reader) (string, error) {
+func ReadInt(r *bufio.Reader) (int, error) {
+  val, err := readUvarint(r)
+  if err != nil { return 0, err }
+  n := int(val)
+  if n < 0 || uint64(n) != val {
+    return 0, fmt.Errorf("int %d out of range", val)
+  }
+  return n, nil
+}
+
+// ReadSint reads a signed int, which is zigzag-encoded so that small
+// negative numbers stay short: 0, -1, 1, -2, ... are sent as 0, 1, 2, 3, ...
+func ReadSint(r *bufio.Reader) (int, error) {
+  val, err := readUvarint(r)
+  if err != nil { return 0, err }
+  signed := int64(val >> 1) ^ -int64(val & 1)
+  n := int(signed)
+  if int64(n) != signed {
+    return 0, fmt.Errorf("sint %d out of range", signed)
+  }
+  return n, nil
+}
+
+func ReadBytes(r *bufio.Reader) ([]byte, error) {
   n, err := ReadInt(r)
-  if err != nil { return "", err }
-  buf := make([]byte, n)
-  _, err = io.ReadFull(r, buf)
+  if err != nil { return nil, err }
+  if n <= maxPrealloc {
+    buf := make([]byte, n)
+    _, err = io.ReadFull(r, buf)
+    if err != nil { return nil, err }
+    return buf, nil
+  }
+  var buf bytes.Buffer
+  buf.Grow(maxPrealloc)
+  if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+    if err == io.EOF { err = io.ErrUnexpectedEOF }
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
+
+func ReadString(r *bufio.Reader) (string, error) {
+  buf, err := ReadBytes(r)
   if err != nil { return "", err }
   return string(buf), nil
 }
@@ -86,8 +153,7 @@ func WriteUint8(w io.Writer, val byte) error {
   _, err := w.Write(buf[:])
   return err
 }
-func WriteInt(w io.Writer, val int) error {
-  if val < 0 { panic("negative") }
+func writeUvarint(w io.Writer, val uint64) error {
   for {
     b := byte(val & 0b0111_1111)
     val = val >> 7
@@ -98,12 +164,26 @@ func WriteInt(w io.Writer, val int) error {
     }
   }
 }
-func WriteString(w io.Writer, str string) error {
-  if len(str) >= 1<<16 {
-    panic("overlong")
+func WriteInt(w io.Writer, val int) error {
+  if val < 0 {
+    return fmt.Errorf("negative int %d; use sint for signed values", val)
+  }
+  return writeUvarint(w, uint64(val))
+}
+func WriteSint(w io.Writer, val int) error {
+  if val < 0 {
+    return writeUvarint(w, uint64(^val) << 1 | 1)
   }
+  return writeUvarint(w, uint64(val) << 1)
+}
+func WriteBytes(w io.Writer, buf []byte) error {
+  if err := WriteInt(w, len(buf)); err != nil { return err }
+  _, err := w.Write(buf)
+  return err
+}
+func WriteString(w io.Writer, str string) error {
   if err := WriteInt(w, len(str)); err != nil { return err }
-  _, err := w.Write([]byte(str))
+  _, err := io.WriteString(w, str)
   return err
 }
 `);
@@ -141,7 +221,9 @@ func WriteString(w io.Writer, str string) error {
           write(`return alt.Write(w)\n`);
         });
         write(`}\n`);
-        write(`panic("notimpl")\n`);
+        write(
+          `return fmt.Errorf("bad alt %T when writing ${name}", msg.Alt)\n`
+        );
         break;
       case 'struct':
         for (const f of type.fields) {
@@ -175,13 +257,19 @@ func WriteString(w io.Writer, str string) error {
         write(`}\n`);
         break;
       case 'struct':
-        write(`var err error\n`);
-        write(`err = err\n`);
+        // Only reads of primitives assign to err directly.
+        if (
+          type.fields.some(
+            (f) => f.type.kind === 'ref' && primitives.includes(f.type.type)
+          )
+        ) {
+          write(`var err error\n`);
+        }
         for (const field of type.fields) {
           readValue(field.type, `msg.${cap(field.name)}`);
         }
-          write(`return nil\n`);
-          break;
+        write(`return nil\n`);
+        break;
       default:
         write(`panic("notimpl")\n`);
     }
@@ -192,22 +280,19 @@ func WriteString(w io.Writer, str string) error {
     let fn = `${name}.Read`;
     switch (type.kind) {
       case 'ref':
-        switch (type.type) {
-          case 'boolean':
-          case 'uint8':
-          case 'int':
-          case 'string':
-            write(`${name}, err = Read${cap(type.type)}(r)\n`);
-            write(`if err != nil { return err }\n`)
-            return;
+        if (primitives.includes(type.type)) {
+          write(`${name}, err = Read${cap(type.type)}(r)\n`);
+          write(`if err != nil { return err }\n`);
+          return;
         }
         break;
       case 'array':
         write(`{\n`);
         write(`n, err := ReadInt(r)\n`);
         write(`if err != nil { return err }\n`);
-        write(`var val ${typeToGo(type.type)}\n`)
+        // val is declared per element, so that elements don't share slices.
         write(`for i := 0; i < n; i++ {\n`);
+        write(`var val ${typeToGo(type.type)}\n`);
         readValue(type.type, 'val');
         write(`${name} = append(${name}, val)\n`)
         write(`}\n`);
@@ -220,14 +305,10 @@ func WriteString(w io.Writer, str string) error {
   function writeValue(type: proto.Type, name: string) {
     switch (type.kind) {
       case 'ref':
-        let fn = `Write${cap(type.type)}`;
-        switch (type.type) {
-          case 'boolean':
-          case 'uint8':
-          case 'int':
-          case 'string':
-            write(`if err := ${fn}(w, ${name}); err != nil { return err }\n`);
-            return;
+        if (primitives.includes(type.type)) {
+          const fn = `Write${cap(type.type)}`;
+          write(`if err := ${fn}(w, ${name}); err != nil { return err }\n`);
+          return;
         }
         break;
       case 'array':
@@ -246,6 +327,10 @@ func WriteString(w io.Writer, str string) error {
     switch (ref.type) {
       case 'boolean':
         return 'bool';
+      case 'sint':
+        return 'int';
+      case 'bytes':
+        return '[]byte';
       default:
         return ref.type;
     }
@@ -265,6 +350,9 @@ func WriteString(w io.Writer, str string) error {
 function genTS(decls: proto.Named[], write: (out: string) => void) {
   write(`
 export type uint8 = number;
+
+/** Identifies the schema this code was generated from. */
+export const SCHEMA_HASH = '${schemaHash(decls)}';
 `);
   for (const { name, type } of decls) {
     switch (type.kind) {
@@ -292,24 +380,31 @@ private readUint8(): number {
 }
 
 private readInt(): number {
+  // Multiply rather than shift, as shifts truncate to 32 bits.
   let val = 0;
-  let shift = 0;
+  let scale = 1;
   for (;;) {
     const b = this.readUint8();
-    val |= (b & 0x7f) << shift;
+    val += (b & 0x7f) * scale;
     if ((b & 0x80) === 0) break;
-    shift += 7;
+    scale *= 0x80;
   }
   return val;
 }
 
+private readSint(): number {
+  const val = this.readInt();
+  return val % 2 === 0 ? val / 2 : -(val + 1) / 2;
+}
+
 private readBoolean(): boolean {
   return this.readUint8() !== 0;
 }
 
-private readBytes(): DataView {
+private readBytes(): Uint8Array {
   const len = this.readInt();
-  const slice = new DataView(this.view.buffer, this.ofs, len);
+  const offset = this.view.byteOffset + this.ofs;
+  const slice = new Uint8Array(this.view.buffer, offset, len);
   this.ofs += len;
   return slice;
 }
@@ -377,8 +472,8 @@ private readArray<T>(elem: () => T): T[] {
     writeInt(val: number) {
       if (val < 0) throw new Error('negative');
       for (;;) {
-        const b = val & 0x7f;
-        val = val >> 7;
+        const b = val % 0x80;
+        val = Math.floor(val / 0x80);
         if (val === 0) {
           this.writeUint8(b);
           return;
@@ -386,12 +481,18 @@ private readArray<T>(elem: () => T): T[] {
         this.writeUint8(b | 0x80);
       }
     }
-    writeString(str: string) {
-      this.writeInt(str.length);
-      for (let i = 0; i < str.length; i++) {
-        this.buf[this.ofs++] = str.charCodeAt(i);
+    writeSint(val: number) {
+      this.writeInt(val < 0 ? -val * 2 - 1 : val * 2);
+    }
+    writeBytes(bytes: Uint8Array) {
+      this.writeInt(bytes.length);
+      for (const b of bytes) {
+        this.buf[this.ofs++] = b;
       }
     }
+    writeString(str: string) {
+      this.writeBytes(new TextEncoder().encode(str));
+    }
     writeArray<T>(arr: T[], f: (t: T) => void) {
       this.writeInt(arr.length);
       for (const elem of arr) {
@@ -451,7 +552,10 @@ private readArray<T>(elem: () => T): T[] {
     switch (type.type) {
       case 'uint8':
       case 'int':
+      case 'sint':
         return 'number';
+      case 'bytes':
+        return 'Uint8Array';
       case 'boolean':
       case 'string':
       default:
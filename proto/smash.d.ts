@@ -5,11 +5,37 @@
  * See README.md.
  */
 
+/** Non-negative integer, sent as a varint. */
 type int = number;
+/** Signed integer, zigzag-encoded so that small negatives stay short. */
+type sint = number;
 type uint8 = number;
+/** Arbitrary binary data; string is reserved for UTF-8 text. */
+type bytes = Uint8Array;
+
+/**
+ * First message from client to server on connection, sent on its own
+ * rather than as a ClientMessage.  Its layout must never change, so that
+ * a server can read it from any client and refuse one built from a
+ * different schema.
+ */
+interface ClientHello {
+  /** Hash of the schema the client was built from. */
+  schema: string;
+}
 
 /** Message from client to server. */
-type ClientMessage = CompleteRequest | RunRequest | KeyEvent;
+type ClientMessage =
+  | CompleteRequest
+  | RunRequest
+  | KeyEvent
+  | Resize
+  | MouseEvent
+  | Paste
+  | Signal
+  | ScrollbackRequest
+  | RowsRequest
+  | SearchRequest;
 
 /** Request to complete a partial command-line input. */
 interface CompleteRequest {
@@ -27,11 +53,30 @@ interface CompleteResponse {
   completions: string[];
 }
 
+/** Size of a terminal, in character cells. */
+interface TermSize {
+  rows: int;
+  cols: int;
+}
+
 /** Request to spawn a command. */
 interface RunRequest {
   cell: int;
   cwd: string;
   argv: string[];
+  /** Initial terminal size. */
+  size: TermSize;
+  /** Environment variables to set, overriding the server's environment. */
+  setEnv: Pair[];
+  /** Environment variables to remove from the server's environment. */
+  unsetEnv: string[];
+  /**
+   * Lines of scrollback to keep in the terminal, or 0 for the server's
+   * default.  Older lines are moved to an on-disk store that can be paged
+   * through with ScrollbackRequest, or discarded if dropScrollback is set.
+   */
+  scrollback: int;
+  dropScrollback: boolean;
 }
 
 /** Keystroke sent to running command. */
@@ -40,12 +85,138 @@ interface KeyEvent {
   keys: string;
 }
 
+/**
+ * Text pasted into a running command.  Unlike KeyEvent, the server marks
+ * it as pasted if the command enabled bracketed paste mode.
+ */
+interface Paste {
+  cell: int;
+  text: string;
+}
+
+/** Request to send a signal to a running command's process group. */
+interface Signal {
+  cell: int;
+  /** Signal name without the SIG prefix: INT, TERM, KILL, STOP or CONT. */
+  signal: string;
+}
+
+/**
+ * Request for lines [start, end) of a cell's stored scrollback, numbered
+ * from the first line the command output.
+ */
+interface ScrollbackRequest {
+  cell: int;
+  start: int;
+  end: int;
+}
+
+/** Response to a ScrollbackRequest. */
+interface ScrollbackResponse {
+  cell: int;
+  error: string;
+  /** The requested lines, with rows numbered as in the request. */
+  rows: RowSpans[];
+}
+
+/**
+ * Request for rows [start, end) of a cell's terminal, as numbered in
+ * TermUpdate, for rows the client hasn't loaded.  The server replies
+ * with a RowsResponse.
+ */
+interface RowsRequest {
+  cell: int;
+  start: int;
+  end: int;
+}
+
+/**
+ * Request to search the terminals of the session's cells.  The server
+ * replies with a SearchResponse.
+ */
+interface SearchRequest {
+  /** Id to match the response to the request. */
+  id: int;
+  query: string;
+  /** Whether query is a regular expression (RE2 syntax) or literal text. */
+  regex: boolean;
+  ignoreCase: boolean;
+  /** Cells to search; all cells if empty. */
+  cells: int[];
+}
+
+/** A match found by a SearchRequest. */
+interface SearchMatch {
+  cell: int;
+  /**
+   * Row of the match, as numbered in TermUpdate, or if history is set,
+   * the line of stored scrollback, as numbered in ScrollbackRequest.
+   */
+  row: int;
+  history: boolean;
+  /** Range [start, end) of terminal columns matched. */
+  start: int;
+  end: int;
+  /** Text of the row: before the match, the match, and after it. */
+  before: string;
+  match: string;
+  after: string;
+}
+
+/** Response to a SearchRequest. */
+interface SearchResponse {
+  id: int;
+  error: string;
+  /** Matches in cell and row order. */
+  matches: SearchMatch[];
+  /** Whether there were more matches than returned. */
+  truncated: boolean;
+}
+
+/** Change of the terminal size of a running command. */
+interface Resize {
+  cell: int;
+  size: TermSize;
+}
+
+/**
+ * Mouse action on a running command's terminal, forwarded to the command
+ * if it enabled mouse reporting.
+ */
+interface MouseEvent {
+  cell: int;
+  /** 0 = press, 1 = release, 2 = move. */
+  action: uint8;
+  /**
+   * 0 = left, 1 = middle, 2 = right, 3 = none (for moves),
+   * 4 = wheel up, 5 = wheel down.
+   */
+  button: uint8;
+  /** Position as a row of output (counting scrollback) and column. */
+  row: int;
+  col: int;
+  shift: boolean;
+  alt: boolean;
+  ctrl: boolean;
+}
+
 interface RowSpans {
   row: int;
   spans: Span[];
 }
 interface Span {
+  /**
+   * Attribute flags, as in vt100.Attr.Flags: bit 0 bright, 1 inverse,
+   * 2 faint, 3 italic, 4-6 underline style (1 single, 2 double, 3 curly,
+   * 4 dotted, 5 dashed), 7 blink, 8 hidden, 9 strikethrough.
+   */
   attr: int;
+  /**
+   * Colors: 0 is the default, 1-256 are palette colors 0-255, and
+   * 0x1000000|0xRRGGBB is a direct RGB color.
+   */
+  fg: int;
+  bg: int;
   text: string;
 }
 interface Cursor {
@@ -62,6 +233,30 @@ interface TermUpdate {
   cursor: Cursor;
   /** Total count of lines in the terminal, may go down on scrolling up. */
   rowCount: int;
+  /**
+   * Lines removed from the top of the terminal since the last update,
+   * to be removed before applying this update.  Rows are numbered after
+   * the removal.
+   */
+  trimmed: int;
+  /** Lines of stored scrollback before the terminal's first row. */
+  history: int;
+  /**
+   * True while the alternate screen is active.  Switching screens replaces
+   * all rows, so updates that flip this also resend every row.
+   */
+  altScreen: boolean;
+  /**
+   * Mouse reporting requested by the command: 0 = none, 1 = presses,
+   * 2 = presses and releases, 3 = also drags, 4 = all motion.
+   */
+  mouse: uint8;
+  /** Cursor keys should send SS3 (ESC O) sequences rather than CSI. */
+  appCursorKeys: boolean;
+  /** Keypad keys should send SS3 sequences rather than their characters. */
+  appKeypad: boolean;
+  /** Pasted text is wrapped in ESC[200~ and ESC[201~. */
+  bracketedPaste: boolean;
 }
 
 interface Pair {
@@ -69,24 +264,84 @@ interface Pair {
   val: string;
 }
 
+interface CmdError {
+  error: string;
+}
+interface Exit {
+  /** Exit code; for death by signal, 128 + the signal number. */
+  exitCode: int;
+  /** Name of the signal that killed the process, e.g. "SEGV", or empty. */
+  signal: string;
+  coreDumped: boolean;
+  /** Wall clock time of the command. */
+  wallMs: int;
+  /** CPU time of the process and its waited-for children. */
+  userMs: int;
+  sysMs: int;
+  /** Peak resident set size. */
+  maxRssKb: int;
+}
+/** Input (keys, mouse or paste) that couldn't be delivered to a command. */
+interface InputError {
+  error: string;
+}
+/** Result of a Signal request. */
+interface SignalResult {
+  signal: string;
+  /** Empty if the signal was delivered. */
+  error: string;
+}
+
+/** Snapshot of a cell's state, sent to a (re)attaching client. */
+interface CellState {
+  cell: int;
+  cwd: string;
+  argv: string[];
+  /** True while the subprocess is still running. */
+  running: boolean;
+  /** Error from spawning the command, if any. */
+  error: string;
+  /** Exit status; only meaningful when not running. */
+  exit: Exit;
+  /**
+   * Terminal contents.  Only the rows on screen are included; the client
+   * fetches earlier rows with RowsRequest as needed.
+   */
+  term: TermUpdate;
+}
+
 /** Message from server to client on connection. */
 interface Hello {
+  /** Session id; pass it back on reconnect to reattach to the session. */
+  session: string;
+
   /** Command aliases, from alias name to expansion. */
   alias: Pair[];
 
   /** Environment variables. */
   env: Pair[];
 
-  // TODO: running cells and their state.
+  /** Cells in the session, both running and finished. */
+  cells: CellState[];
 }
 
-interface CmdError {
-  error: string;
-}
-interface Exit {
-  exitCode: int;
+/**
+ * Rows of a terminal, in response to a RowsRequest.  It is ordered with
+ * the cell's TermUpdates, so rows are numbered as of the updates before
+ * it, which may differ from the request if lines were trimmed since.
+ * Rows outside the terminal are left out.
+ */
+interface RowsResponse {
+  rows: RowSpans[];
 }
-type Output = CmdError | TermUpdate | Exit;
+
+type Output =
+  | CmdError
+  | TermUpdate
+  | Exit
+  | SignalResult
+  | InputError
+  | RowsResponse;
 
 /** Message from server to client about a running subprocess. */
 interface CellOutput {
@@ -94,4 +349,44 @@ interface CellOutput {
   output: Output;
 }
 
-type ServerMsg = Hello | CompleteResponse | CellOutput;
+type ServerMsg =
+  | Hello
+  | CompleteResponse
+  | CellOutput
+  | ScrollbackResponse
+  | SearchResponse;
+
+/**
+ * Request from `smash <command> args...`, run within a smash cell, sent to
+ * the server over the socket named by $SMASH_SOCK.  It is followed by the
+ * command's stdin, as LocalStdin messages.
+ */
+interface LocalRequest {
+  /** Subcommand name, such as "that". */
+  command: string;
+  /** Arguments following the subcommand name. */
+  args: string[];
+}
+
+/** A chunk of a local command's stdin; empty at end of file. */
+interface LocalStdin {
+  data: bytes;
+}
+
+/** Message from a local command to the server. */
+type LocalClientMsg = LocalRequest | LocalStdin;
+
+/** A chunk of a local command's output. */
+interface LocalOutput {
+  /** True if the output is for stderr rather than stdout. */
+  stderr: boolean;
+  data: bytes;
+}
+
+/** The local command finished; the last message on the connection. */
+interface LocalExit {
+  code: int;
+}
+
+/** Message from the server to a local command. */
+type LocalServerMsg = LocalOutput | LocalExit;
@@ -3,6 +3,7 @@ import { htext, html } from './html';
 import * as proto from './proto';
 import * as readline from './readline';
 import { ReadLine } from './readline';
+import { Scrollback } from './scrollback';
 import * as sh from './shell';
 import { Shell } from './shell';
 import { Term } from './term';
@@ -15,13 +16,63 @@ interface PendingComplete {
   reject: () => void;
 }
 
+/** Signals offered for running commands, with their button labels. */
+const SIGNAL_BUTTONS = [
+  ['INT', 'interrupt'],
+  ['TERM', 'terminate'],
+  ['KILL', 'kill'],
+  ['STOP', 'stop'],
+  ['CONT', 'continue'],
+];
+
+function formatMs(ms: number): string {
+  return ms < 1000 ? `${ms}ms` : `${(ms / 1000).toFixed(1)}s`;
+}
+
+/** Describes an unsuccessful exit, or returns undefined for success. */
+function exitSummary(exit: proto.Exit): string | undefined {
+  if (exit.exitCode === 0 && !exit.signal) return;
+  let summary = exit.signal
+    ? `killed by SIG${exit.signal}`
+    : `exit code ${exit.exitCode}`;
+  if (exit.coreDumped) summary += ' (core dumped)';
+  const mb = (exit.maxRssKb / 1024).toFixed(1);
+  summary +=
+    ` after ${formatMs(exit.wallMs)}` +
+    ` (user ${formatMs(exit.userMs)}, sys ${formatMs(exit.sysMs)},` +
+    ` max RSS ${mb}MB)`;
+  return summary;
+}
+
 class Cell {
   dom = html('div', { className: 'cell' });
   readline = new ReadLine(history);
   term = new Term();
+  /** Output trimmed from the terminal, kept by the server. */
+  scrollback: Scrollback;
   /** Did the subprocess produce any output? */
   didOutput = false;
+  /** Has a CmdError been displayed? */
+  errorShown = false;
+  /** Has the exit status been displayed? */
+  exitShown = false;
   running: sh.ExecRemote | null = null;
+  /** Terminal size last sent to the server. */
+  size: proto.TermSize = { rows: 0, cols: 0 };
+  /** Shows the latest input the command didn't accept, if any. */
+  inputError = html('div', { className: 'input-error' });
+  /** Buttons for signaling the running command. */
+  signals = html(
+    'div',
+    { className: 'signals' },
+    ...SIGNAL_BUTTONS.map(([signal, title]) =>
+      html(
+        'button',
+        { title: `send SIG${signal}`, onclick: () => this.sendSignal(signal) },
+        htext(title)
+      )
+    )
+  );
 
   delegates = {
     /** Called when the subprocess exits. */
@@ -29,17 +80,38 @@ class Cell {
 
     /** Sends a server message. */
     send: (msg: proto.ClientMessage) => {},
+
+    /** Shows a row of a cell's output, as found by a search. */
+    reveal: (match: proto.SearchMatch) => {},
   };
 
   pendingComplete?: PendingComplete;
+  /** Whether a search is waiting on its SearchResponse. */
+  searching = false;
 
   constructor(readonly id: number, readonly shell: Shell) {
     this.dom.appendChild(this.readline.dom);
+    this.scrollback = new Scrollback(id);
+    this.scrollback.delegates = {
+      send: (msg) => this.delegates.send(msg),
+    };
     this.term.delegates = {
       key: (key) => {
         key.cell = this.id;
         this.delegates.send({ tag: 'KeyEvent', val: key });
       },
+      mouse: (mouse) => {
+        mouse.cell = this.id;
+        this.delegates.send({ tag: 'MouseEvent', val: mouse });
+      },
+      paste: (paste) => {
+        paste.cell = this.id;
+        this.delegates.send({ tag: 'Paste', val: paste });
+      },
+      rows: (req) => {
+        req.cell = this.id;
+        this.delegates.send({ tag: 'RowsRequest', val: req });
+      },
     };
 
     this.readline.delegates = {
@@ -75,13 +147,20 @@ class Cell {
             break;
           case 'remote':
             this.running = exec;
+            this.dom.appendChild(this.signals);
+            this.dom.appendChild(this.scrollback.dom);
             this.spawn(this.id, exec);
             // The result of spawning will come back in via a message in onOutput().
             break;
+          case 'search':
+            this.term.dom = html('div', { className: 'search' });
+            this.search(exec);
+            // Results come back in onSearchResponse().
+            break;
         }
         this.dom.appendChild(this.term.dom);
         this.term.dom.focus();
-        if (!this.running) {
+        if (!this.running && !this.searching) {
           this.delegates.exit(this.id, 0);
         }
       },
@@ -106,22 +185,106 @@ class Cell {
   }
 
   spawn(id: number, cmd: sh.ExecRemote) {
+    this.size = this.term.fitSize();
+    // Scrollback limits come from shell variables, like bash's HISTSIZE.
+    const env = this.shell.env;
+    const scrollback = parseInt(env.get('SMASH_SCROLLBACK') || '', 10);
     const run: proto.RunRequest = {
       cell: id,
       cwd: cmd.cwd,
       argv: cmd.cmd,
+      size: this.size,
+      setEnv: (cmd.setEnv || []).map(([key, val]) => ({ key, val })),
+      unsetEnv: cmd.unsetEnv || [],
+      scrollback: scrollback > 0 ? scrollback : 0,
+      dropScrollback: !!env.get('SMASH_SCROLLBACK_DROP'),
     };
     this.delegates.send({ tag: 'RunRequest', val: run });
   }
 
+  search(search: sh.SearchQuery) {
+    const req: proto.SearchRequest = {
+      id: this.id,
+      query: search.query,
+      regex: search.regex,
+      ignoreCase: search.ignoreCase,
+      cells: search.cells,
+    };
+    this.delegates.send({ tag: 'SearchRequest', val: req });
+    this.searching = true;
+  }
+
+  onSearchResponse(msg: proto.SearchResponse) {
+    if (!this.searching) return;
+    this.searching = false;
+    const dom = this.term.dom;
+    if (msg.error) {
+      dom.appendChild(htext(msg.error));
+    } else {
+      const more = msg.truncated ? '+' : '';
+      const count = `${msg.matches.length}${more} matches`;
+      dom.appendChild(html('div', {}, htext(count)));
+      for (const m of msg.matches) {
+        dom.appendChild(
+          html(
+            'div',
+            {
+              className: 'search-match',
+              onclick: () => this.delegates.reveal(m),
+            },
+            htext(`${m.cell}:${m.history ? 'h' : ''}${m.row + 1}: ${m.before}`),
+            html('mark', {}, htext(m.match)),
+            htext(m.after)
+          )
+        );
+      }
+    }
+    this.delegates.exit(this.id, 0);
+  }
+
+  /** Updates the terminal size of a running command to fit the window. */
+  onResize() {
+    if (!this.running) return;
+    const size = this.term.fitSize();
+    if (size.rows === this.size.rows && size.cols === this.size.cols) return;
+    this.size = size;
+    this.delegates.send({ tag: 'Resize', val: { cell: this.id, size } });
+  }
+
+  sendSignal(signal: string) {
+    this.delegates.send({ tag: 'Signal', val: { cell: this.id, signal } });
+    this.focus();
+  }
+
   onOutput(msg: proto.Output) {
     switch (msg.tag) {
       case 'CmdError':
         // error; exit code will come later.
         this.dom.appendChild(html('div', {}, htext(msg.val.error)));
+        this.errorShown = true;
+        break;
+      case 'SignalResult':
+        if (msg.val.error) {
+          this.dom.insertBefore(
+            html('div', {}, htext(msg.val.error)),
+            this.term.dom
+          );
+        }
+        break;
+      case 'InputError':
+        // Reuse one element, so repeated keypresses don't pile up errors.
+        this.inputError.textContent = msg.val.error;
+        if (!this.inputError.parentNode) {
+          this.dom.insertBefore(this.inputError, this.term.dom);
+        }
+        break;
+      case 'RowsResponse':
+        this.term.onRows(msg.val);
         break;
       case 'TermUpdate':
         this.didOutput = true;
+        const trimmed = this.term.trim(msg.val.trimmed);
+        this.scrollback.onTrim(trimmed, msg.val.history);
         this.term.onUpdate(msg.val);
         break;
       case 'Exit':
@@ -132,16 +295,65 @@ class Cell {
           this.running.onComplete(exitCode);
         }
         this.running = null;
-        this.term.showCursor(false);
-        this.term.preventFocus();
-        if (!this.didOutput) {
-          // Remove the vertical space of the terminal.
-          this.term.dom.innerText = '';
-        }
+        this.finish(msg.val);
         this.delegates.exit(this.id, exitCode);
     }
   }
 
+  /** Puts the terminal into its final, no longer interactive, state. */
+  private finish(exit: proto.Exit) {
+    this.signals.remove();
+    const summary = exitSummary(exit);
+    if (summary && !this.exitShown && !this.errorShown) {
+      this.dom.appendChild(html('div', { className: 'exit' }, htext(summary)));
+      this.exitShown = true;
+    }
+    this.term.showCursor(false);
+    this.term.preventFocus();
+    if (!this.didOutput) {
+      // Remove the vertical space of the terminal.
+      this.term.dom.innerText = '';
+    }
+  }
+
+  /**
+   * Brings the cell up to date with its server-side state, as sent when
+   * (re)attaching to a session.
+   */
+  restore(state: proto.CellState) {
+    this.readline.setPrompt(this.shell.cwdForPrompt(state.cwd));
+    this.readline.setText(sh.argvToCmd(state.argv));
+    if (state.error && !this.errorShown) {
+      this.dom.appendChild(html('div', {}, htext(state.error)));
+      this.errorShown = true;
+    }
+    if (!this.term.dom.parentNode) {
+      this.dom.appendChild(this.term.dom);
+    }
+    if (!this.scrollback.dom.parentNode) {
+      this.dom.insertBefore(this.scrollback.dom, this.term.dom);
+    }
+    // Rows before those sent must have scrolled off, so there was output.
+    this.didOutput =
+      state.term.rows.some((row) => row.spans.length > 0) ||
+      state.term.rows.length < state.term.rowCount;
+    this.scrollback.onTrim([], state.term.history);
+    this.term.clearRows();
+    this.term.onUpdate(state.term);
+
+    if (state.running) {
+      if (!this.running) {
+        this.running = { kind: 'remote', cwd: state.cwd, cmd: state.argv };
+      }
+      this.dom.insertBefore(this.signals, this.term.dom);
+    } else if (this.running) {
+      // Exited while we were disconnected.
+      this.onOutput({ tag: 'Exit', val: state.exit });
+    } else {
+      this.finish(state.exit);
+    }
+  }
+
   onCompleteResponse(msg: proto.CompleteResponse) {
     if (!this.pendingComplete) return;
     this.pendingComplete.resolve({
@@ -169,38 +381,85 @@ function scrollToBottom(el: HTMLElement) {
 export class CellStack {
   dom = html('div', { className: 'cellstack' });
   cells: Cell[] = [];
+  /** Id for the next cell; cells restored from the server may skip ids. */
+  nextId = 0;
   delegates = {
     send: (msg: proto.ClientMessage) => {},
   };
 
-  constructor(readonly shell: Shell) {
-    this.addNew();
+  constructor(readonly shell: Shell, cells: proto.CellState[] = []) {
+    this.restore(cells);
+    const last = this.cells[this.cells.length - 1];
+    if (!last || !last.running) {
+      this.addNew();
+    }
   }
 
-  addNew() {
-    const id = this.cells.length;
+  /** Updates cells to match server state, creating any that are missing. */
+  restore(states: proto.CellState[]) {
+    for (const state of states) {
+      let cell = this.getCell(state.cell);
+      if (!cell) cell = this.createCell(state.cell);
+      cell.restore(state);
+    }
+  }
+
+  private createCell(id: number): Cell {
     const cell = new Cell(id, this.shell);
-    cell.readline.setPrompt(this.shell.cwdForPrompt());
     cell.delegates = {
       send: (msg) => this.delegates.send(msg),
       exit: (id: number, exitCode: number) => {
         this.onExit(id, exitCode);
       },
+      reveal: (match: proto.SearchMatch) => {
+        const cell = this.getCell(match.cell);
+        if (match.history) {
+          cell?.scrollback.reveal(match.row);
+        } else {
+          cell?.term.revealRow(match.row);
+        }
+      },
     };
     this.cells.push(cell);
     this.dom.appendChild(cell.dom);
+    this.nextId = Math.max(this.nextId, id + 1);
+    return cell;
+  }
+
+  addNew() {
+    const cell = this.createCell(this.nextId);
+    cell.readline.setPrompt(this.shell.cwdForPrompt());
     cell.readline.input.focus();
     scrollToBottom(cell.dom);
   }
 
+  onResize() {
+    for (const cell of this.cells) {
+      cell.onResize();
+    }
+  }
+
+  getCell(id: number): Cell | undefined {
+    return this.cells.find((cell) => cell.id === id);
+  }
+
   onOutput(msg: proto.CellOutput) {
-    const cell = this.cells[msg.cell];
+    const cell = this.getCell(msg.cell);
+    if (!cell) return;
     cell.onOutput(msg.output);
-    if (msg.cell === this.cells.length - 1) {
+    if (cell === this.getLastCell()) {
       scrollToBottom(cell.dom);
     }
   }
 
+  onSearch(msg: proto.SearchResponse) {
+    this.getCell(msg.id)?.onSearchResponse(msg);
+  }
+
+  onScrollback(msg: proto.ScrollbackResponse) {
+    this.getCell(msg.cell)?.scrollback.onResponse(msg);
+  }
+
   onExit(id: number, exitCode: number) {
     this.addNew();
   }
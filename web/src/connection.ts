@@ -6,6 +6,9 @@ import * as proto from './proto';
 
 const TRACE_MESSAGES = true;
 
+/** sessionStorage key holding the server session id. */
+const SESSION_KEY = 'smash-session';
+
 /** Prints a proto-encoded message. */
 function printMessage(prefix: string, msg: any) {
   if ('tag' in msg) {
@@ -29,6 +32,18 @@ function parseMessage(event: MessageEvent): proto.ServerMsg {
   return new proto.Reader(new DataView(event.data)).readServerMsg();
 }
 
+/** Encodes a message with a Writer method such as writeClientMessage. */
+function encode(write: (writer: proto.Writer) => void): Uint8Array {
+  // Write once with an empty buffer to measure, then a second time after
+  // creating the buffer.
+  const writer = new proto.Writer();
+  write(writer);
+  writer.buf = new Uint8Array(writer.ofs);
+  writer.ofs = 0;
+  write(writer);
+  return writer.buf;
+}
+
 /** Promisifies WebSocket connection. */
 function connect(ws: WebSocket): Promise<void> {
   return new Promise((res, rej) => {
@@ -68,10 +83,14 @@ export class ServerConnection {
 
   /**
    * Opens the connection to the server.
+   * Reattaches to the previous session, if any, so that reloading the page
+   * picks up the cells that were running before.
    */
   async connect(): Promise<proto.Hello> {
     const url = new URL('/ws', window.location.href);
     url.protocol = url.protocol.replace('http', 'ws');
+    const session = sessionStorage.getItem(SESSION_KEY);
+    if (session) url.searchParams.set('session', session);
     const ws = new WebSocket(url.href);
     ws.binaryType = 'arraybuffer';
     await connect(ws);
@@ -80,10 +99,18 @@ export class ServerConnection {
     };
     this.ws = ws;
 
+    // The server checks that we were built from the same schema before
+    // sending anything, and closes the connection with a reason if not.
+    const hello: proto.ClientHello = {
+      schema: proto.SCHEMA_HASH,
+    };
+    ws.send(encode((writer) => writer.writeClientHello(hello)));
+
     const msg = await read(ws);
     if (msg.tag !== 'Hello') {
       throw new Error(`expected hello message, got ${msg}`);
     }
+    sessionStorage.setItem(SESSION_KEY, msg.val.session);
     return msg.val;
   }
 
@@ -95,14 +122,6 @@ export class ServerConnection {
 
   send(msg: proto.ClientMessage) {
     if (TRACE_MESSAGES) printMessage('send: ', msg);
-
-    // Write once with an empty buffer to measure, then a second time after
-    // creating the buffer.
-    const writer = new proto.Writer();
-    writer.writeClientMessage(msg);
-    writer.buf = new Uint8Array(writer.ofs);
-    writer.ofs = 0;
-    writer.writeClientMessage(msg);
-    this.ws.send(writer.buf);
+    this.ws.send(encode((writer) => writer.writeClientMessage(msg)));
   }
 }
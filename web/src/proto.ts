@@ -1,8 +1,21 @@
 export type uint8 = number;
+
+/** Identifies the schema this code was generated from. */
+export const SCHEMA_HASH = '95a117bbe4e82848';
+export interface ClientHello {
+  schema: string;
+}
 export type ClientMessage =
   | { tag: 'CompleteRequest'; val: CompleteRequest }
   | { tag: 'RunRequest'; val: RunRequest }
-  | { tag: 'KeyEvent'; val: KeyEvent };
+  | { tag: 'KeyEvent'; val: KeyEvent }
+  | { tag: 'Resize'; val: Resize }
+  | { tag: 'MouseEvent'; val: MouseEvent }
+  | { tag: 'Paste'; val: Paste }
+  | { tag: 'Signal'; val: Signal }
+  | { tag: 'ScrollbackRequest'; val: ScrollbackRequest }
+  | { tag: 'RowsRequest'; val: RowsRequest }
+  | { tag: 'SearchRequest'; val: SearchRequest };
 export interface CompleteRequest {
   id: number;
   cwd: string;
@@ -15,21 +28,92 @@ export interface CompleteResponse {
   pos: number;
   completions: string[];
 }
+export interface TermSize {
+  rows: number;
+  cols: number;
+}
 export interface RunRequest {
   cell: number;
   cwd: string;
   argv: string[];
+  size: TermSize;
+  setEnv: Pair[];
+  unsetEnv: string[];
+  scrollback: number;
+  dropScrollback: boolean;
 }
 export interface KeyEvent {
   cell: number;
   keys: string;
 }
+export interface Paste {
+  cell: number;
+  text: string;
+}
+export interface Signal {
+  cell: number;
+  signal: string;
+}
+export interface ScrollbackRequest {
+  cell: number;
+  start: number;
+  end: number;
+}
+export interface ScrollbackResponse {
+  cell: number;
+  error: string;
+  rows: RowSpans[];
+}
+export interface RowsRequest {
+  cell: number;
+  start: number;
+  end: number;
+}
+export interface SearchRequest {
+  id: number;
+  query: string;
+  regex: boolean;
+  ignoreCase: boolean;
+  cells: number[];
+}
+export interface SearchMatch {
+  cell: number;
+  row: number;
+  history: boolean;
+  start: number;
+  end: number;
+  before: string;
+  match: string;
+  after: string;
+}
+export interface SearchResponse {
+  id: number;
+  error: string;
+  matches: SearchMatch[];
+  truncated: boolean;
+}
+export interface Resize {
+  cell: number;
+  size: TermSize;
+}
+export interface MouseEvent {
+  cell: number;
+  action: number;
+  button: number;
+  row: number;
+  col: number;
+  shift: boolean;
+  alt: boolean;
+  ctrl: boolean;
+}
 export interface RowSpans {
   row: number;
   spans: Span[];
 }
 export interface Span {
   attr: number;
+  fg: number;
+  bg: number;
   text: string;
 }
 export interface Cursor {
@@ -41,25 +125,62 @@ export interface TermUpdate {
   rows: RowSpans[];
   cursor: Cursor;
   rowCount: number;
+  trimmed: number;
+  history: number;
+  altScreen: boolean;
+  mouse: number;
+  appCursorKeys: boolean;
+  appKeypad: boolean;
+  bracketedPaste: boolean;
 }
 export interface Pair {
   key: string;
   val: string;
 }
-export interface Hello {
-  alias: Pair[];
-  env: Pair[];
-}
 export interface CmdError {
   error: string;
 }
 export interface Exit {
   exitCode: number;
+  signal: string;
+  coreDumped: boolean;
+  wallMs: number;
+  userMs: number;
+  sysMs: number;
+  maxRssKb: number;
+}
+export interface InputError {
+  error: string;
+}
+export interface SignalResult {
+  signal: string;
+  error: string;
+}
+export interface CellState {
+  cell: number;
+  cwd: string;
+  argv: string[];
+  running: boolean;
+  error: string;
+  exit: Exit;
+  term: TermUpdate;
+}
+export interface Hello {
+  session: string;
+  alias: Pair[];
+  env: Pair[];
+  cells: CellState[];
+}
+export interface RowsResponse {
+  rows: RowSpans[];
 }
 export type Output =
   | { tag: 'CmdError'; val: CmdError }
   | { tag: 'TermUpdate'; val: TermUpdate }
-  | { tag: 'Exit'; val: Exit };
+  | { tag: 'Exit'; val: Exit }
+  | { tag: 'SignalResult'; val: SignalResult }
+  | { tag: 'InputError'; val: InputError }
+  | { tag: 'RowsResponse'; val: RowsResponse };
 export interface CellOutput {
   cell: number;
   output: Output;
@@ -67,7 +188,29 @@ export interface CellOutput {
 export type ServerMsg =
   | { tag: 'Hello'; val: Hello }
   | { tag: 'CompleteResponse'; val: CompleteResponse }
-  | { tag: 'CellOutput'; val: CellOutput };
+  | { tag: 'CellOutput'; val: CellOutput }
+  | { tag: 'ScrollbackResponse'; val: ScrollbackResponse }
+  | { tag: 'SearchResponse'; val: SearchResponse };
+export interface LocalRequest {
+  command: string;
+  args: string[];
+}
+export interface LocalStdin {
+  data: Uint8Array;
+}
+export type LocalClientMsg =
+  | { tag: 'LocalRequest'; val: LocalRequest }
+  | { tag: 'LocalStdin'; val: LocalStdin };
+export interface LocalOutput {
+  stderr: boolean;
+  data: Uint8Array;
+}
+export interface LocalExit {
+  code: number;
+}
+export type LocalServerMsg =
+  | { tag: 'LocalOutput'; val: LocalOutput }
+  | { tag: 'LocalExit'; val: LocalExit };
 export class Reader {
   private ofs = 0;
   constructor(readonly view: DataView) {}
@@ -77,24 +220,31 @@ export class Reader {
   }
 
   private readInt(): number {
+    // Multiply rather than shift, as shifts truncate to 32 bits.
     let val = 0;
-    let shift = 0;
+    let scale = 1;
     for (;;) {
       const b = this.readUint8();
-      val |= (b & 0x7f) << shift;
+      val += (b & 0x7f) * scale;
       if ((b & 0x80) === 0) break;
-      shift += 7;
+      scale *= 0x80;
     }
     return val;
   }
 
+  private readSint(): number {
+    const val = this.readInt();
+    return val % 2 === 0 ? val / 2 : -(val + 1) / 2;
+  }
+
   private readBoolean(): boolean {
     return this.readUint8() !== 0;
   }
 
-  private readBytes(): DataView {
+  private readBytes(): Uint8Array {
     const len = this.readInt();
-    const slice = new DataView(this.view.buffer, this.ofs, len);
+    const offset = this.view.byteOffset + this.ofs;
+    const slice = new Uint8Array(this.view.buffer, offset, len);
     this.ofs += len;
     return slice;
   }
@@ -112,6 +262,11 @@ export class Reader {
     }
     return arr;
   }
+  readClientHello(): ClientHello {
+    return {
+      schema: this.readString(),
+    };
+  }
   readClientMessage(): ClientMessage {
     switch (this.readUint8()) {
       case 1:
@@ -120,6 +275,20 @@ export class Reader {
         return { tag: 'RunRequest', val: this.readRunRequest() };
       case 3:
         return { tag: 'KeyEvent', val: this.readKeyEvent() };
+      case 4:
+        return { tag: 'Resize', val: this.readResize() };
+      case 5:
+        return { tag: 'MouseEvent', val: this.readMouseEvent() };
+      case 6:
+        return { tag: 'Paste', val: this.readPaste() };
+      case 7:
+        return { tag: 'Signal', val: this.readSignal() };
+      case 8:
+        return { tag: 'ScrollbackRequest', val: this.readScrollbackRequest() };
+      case 9:
+        return { tag: 'RowsRequest', val: this.readRowsRequest() };
+      case 10:
+        return { tag: 'SearchRequest', val: this.readSearchRequest() };
       default:
         throw new Error('parse error');
     }
@@ -140,11 +309,22 @@ export class Reader {
       completions: this.readArray(() => this.readString()),
     };
   }
+  readTermSize(): TermSize {
+    return {
+      rows: this.readInt(),
+      cols: this.readInt(),
+    };
+  }
   readRunRequest(): RunRequest {
     return {
       cell: this.readInt(),
       cwd: this.readString(),
       argv: this.readArray(() => this.readString()),
+      size: this.readTermSize(),
+      setEnv: this.readArray(() => this.readPair()),
+      unsetEnv: this.readArray(() => this.readString()),
+      scrollback: this.readInt(),
+      dropScrollback: this.readBoolean(),
     };
   }
   readKeyEvent(): KeyEvent {
@@ -153,6 +333,86 @@ export class Reader {
       keys: this.readString(),
     };
   }
+  readPaste(): Paste {
+    return {
+      cell: this.readInt(),
+      text: this.readString(),
+    };
+  }
+  readSignal(): Signal {
+    return {
+      cell: this.readInt(),
+      signal: this.readString(),
+    };
+  }
+  readScrollbackRequest(): ScrollbackRequest {
+    return {
+      cell: this.readInt(),
+      start: this.readInt(),
+      end: this.readInt(),
+    };
+  }
+  readScrollbackResponse(): ScrollbackResponse {
+    return {
+      cell: this.readInt(),
+      error: this.readString(),
+      rows: this.readArray(() => this.readRowSpans()),
+    };
+  }
+  readRowsRequest(): RowsRequest {
+    return {
+      cell: this.readInt(),
+      start: this.readInt(),
+      end: this.readInt(),
+    };
+  }
+  readSearchRequest(): SearchRequest {
+    return {
+      id: this.readInt(),
+      query: this.readString(),
+      regex: this.readBoolean(),
+      ignoreCase: this.readBoolean(),
+      cells: this.readArray(() => this.readInt()),
+    };
+  }
+  readSearchMatch(): SearchMatch {
+    return {
+      cell: this.readInt(),
+      row: this.readInt(),
+      history: this.readBoolean(),
+      start: this.readInt(),
+      end: this.readInt(),
+      before: this.readString(),
+      match: this.readString(),
+      after: this.readString(),
+    };
+  }
+  readSearchResponse(): SearchResponse {
+    return {
+      id: this.readInt(),
+      error: this.readString(),
+      matches: this.readArray(() => this.readSearchMatch()),
+      truncated: this.readBoolean(),
+    };
+  }
+  readResize(): Resize {
+    return {
+      cell: this.readInt(),
+      size: this.readTermSize(),
+    };
+  }
+  readMouseEvent(): MouseEvent {
+    return {
+      cell: this.readInt(),
+      action: this.readUint8(),
+      button: this.readUint8(),
+      row: this.readInt(),
+      col: this.readInt(),
+      shift: this.readBoolean(),
+      alt: this.readBoolean(),
+      ctrl: this.readBoolean(),
+    };
+  }
   readRowSpans(): RowSpans {
     return {
       row: this.readInt(),
@@ -162,6 +422,8 @@ export class Reader {
   readSpan(): Span {
     return {
       attr: this.readInt(),
+      fg: this.readInt(),
+      bg: this.readInt(),
       text: this.readString(),
     };
   }
@@ -177,6 +439,13 @@ export class Reader {
       rows: this.readArray(() => this.readRowSpans()),
       cursor: this.readCursor(),
       rowCount: this.readInt(),
+      trimmed: this.readInt(),
+      history: this.readInt(),
+      altScreen: this.readBoolean(),
+      mouse: this.readUint8(),
+      appCursorKeys: this.readBoolean(),
+      appKeypad: this.readBoolean(),
+      bracketedPaste: this.readBoolean(),
     };
   }
   readPair(): Pair {
@@ -185,12 +454,6 @@ export class Reader {
       val: this.readString(),
     };
   }
-  readHello(): Hello {
-    return {
-      alias: this.readArray(() => this.readPair()),
-      env: this.readArray(() => this.readPair()),
-    };
-  }
   readCmdError(): CmdError {
     return {
       error: this.readString(),
@@ -199,6 +462,47 @@ export class Reader {
   readExit(): Exit {
     return {
       exitCode: this.readInt(),
+      signal: this.readString(),
+      coreDumped: this.readBoolean(),
+      wallMs: this.readInt(),
+      userMs: this.readInt(),
+      sysMs: this.readInt(),
+      maxRssKb: this.readInt(),
+    };
+  }
+  readInputError(): InputError {
+    return {
+      error: this.readString(),
+    };
+  }
+  readSignalResult(): SignalResult {
+    return {
+      signal: this.readString(),
+      error: this.readString(),
+    };
+  }
+  readCellState(): CellState {
+    return {
+      cell: this.readInt(),
+      cwd: this.readString(),
+      argv: this.readArray(() => this.readString()),
+      running: this.readBoolean(),
+      error: this.readString(),
+      exit: this.readExit(),
+      term: this.readTermUpdate(),
+    };
+  }
+  readHello(): Hello {
+    return {
+      session: this.readString(),
+      alias: this.readArray(() => this.readPair()),
+      env: this.readArray(() => this.readPair()),
+      cells: this.readArray(() => this.readCellState()),
+    };
+  }
+  readRowsResponse(): RowsResponse {
+    return {
+      rows: this.readArray(() => this.readRowSpans()),
     };
   }
   readOutput(): Output {
@@ -209,6 +513,12 @@ export class Reader {
         return { tag: 'TermUpdate', val: this.readTermUpdate() };
       case 3:
         return { tag: 'Exit', val: this.readExit() };
+      case 4:
+        return { tag: 'SignalResult', val: this.readSignalResult() };
+      case 5:
+        return { tag: 'InputError', val: this.readInputError() };
+      case 6:
+        return { tag: 'RowsResponse', val: this.readRowsResponse() };
       default:
         throw new Error('parse error');
     }
@@ -227,6 +537,55 @@ export class Reader {
         return { tag: 'CompleteResponse', val: this.readCompleteResponse() };
       case 3:
         return { tag: 'CellOutput', val: this.readCellOutput() };
+      case 4:
+        return {
+          tag: 'ScrollbackResponse',
+          val: this.readScrollbackResponse(),
+        };
+      case 5:
+        return { tag: 'SearchResponse', val: this.readSearchResponse() };
+      default:
+        throw new Error('parse error');
+    }
+  }
+  readLocalRequest(): LocalRequest {
+    return {
+      command: this.readString(),
+      args: this.readArray(() => this.readString()),
+    };
+  }
+  readLocalStdin(): LocalStdin {
+    return {
+      data: this.readBytes(),
+    };
+  }
+  readLocalClientMsg(): LocalClientMsg {
+    switch (this.readUint8()) {
+      case 1:
+        return { tag: 'LocalRequest', val: this.readLocalRequest() };
+      case 2:
+        return { tag: 'LocalStdin', val: this.readLocalStdin() };
+      default:
+        throw new Error('parse error');
+    }
+  }
+  readLocalOutput(): LocalOutput {
+    return {
+      stderr: this.readBoolean(),
+      data: this.readBytes(),
+    };
+  }
+  readLocalExit(): LocalExit {
+    return {
+      code: this.readInt(),
+    };
+  }
+  readLocalServerMsg(): LocalServerMsg {
+    switch (this.readUint8()) {
+      case 1:
+        return { tag: 'LocalOutput', val: this.readLocalOutput() };
+      case 2:
+        return { tag: 'LocalExit', val: this.readLocalExit() };
       default:
         throw new Error('parse error');
     }
@@ -245,8 +604,8 @@ export class Writer {
   writeInt(val: number) {
     if (val < 0) throw new Error('negative');
     for (;;) {
-      const b = val & 0x7f;
-      val = val >> 7;
+      const b = val % 0x80;
+      val = Math.floor(val / 0x80);
       if (val === 0) {
         this.writeUint8(b);
         return;
@@ -254,18 +613,27 @@ export class Writer {
       this.writeUint8(b | 0x80);
     }
   }
-  writeString(str: string) {
-    this.writeInt(str.length);
-    for (let i = 0; i < str.length; i++) {
-      this.buf[this.ofs++] = str.charCodeAt(i);
+  writeSint(val: number) {
+    this.writeInt(val < 0 ? -val * 2 - 1 : val * 2);
+  }
+  writeBytes(bytes: Uint8Array) {
+    this.writeInt(bytes.length);
+    for (const b of bytes) {
+      this.buf[this.ofs++] = b;
     }
   }
+  writeString(str: string) {
+    this.writeBytes(new TextEncoder().encode(str));
+  }
   writeArray<T>(arr: T[], f: (t: T) => void) {
     this.writeInt(arr.length);
     for (const elem of arr) {
       f(elem);
     }
   }
+  writeClientHello(msg: ClientHello) {
+    this.writeString(msg.schema);
+  }
   writeClientMessage(msg: ClientMessage) {
     switch (msg.tag) {
       case 'CompleteRequest':
@@ -280,6 +648,34 @@ export class Writer {
         this.writeUint8(3);
         this.writeKeyEvent(msg.val);
         break;
+      case 'Resize':
+        this.writeUint8(4);
+        this.writeResize(msg.val);
+        break;
+      case 'MouseEvent':
+        this.writeUint8(5);
+        this.writeMouseEvent(msg.val);
+        break;
+      case 'Paste':
+        this.writeUint8(6);
+        this.writePaste(msg.val);
+        break;
+      case 'Signal':
+        this.writeUint8(7);
+        this.writeSignal(msg.val);
+        break;
+      case 'ScrollbackRequest':
+        this.writeUint8(8);
+        this.writeScrollbackRequest(msg.val);
+        break;
+      case 'RowsRequest':
+        this.writeUint8(9);
+        this.writeRowsRequest(msg.val);
+        break;
+      case 'SearchRequest':
+        this.writeUint8(10);
+        this.writeSearchRequest(msg.val);
+        break;
     }
   }
   writeCompleteRequest(msg: CompleteRequest) {
@@ -296,17 +692,96 @@ export class Writer {
       this.writeString(val);
     });
   }
+  writeTermSize(msg: TermSize) {
+    this.writeInt(msg.rows);
+    this.writeInt(msg.cols);
+  }
   writeRunRequest(msg: RunRequest) {
     this.writeInt(msg.cell);
     this.writeString(msg.cwd);
     this.writeArray(msg.argv, (val) => {
       this.writeString(val);
     });
+    this.writeTermSize(msg.size);
+    this.writeArray(msg.setEnv, (val) => {
+      this.writePair(val);
+    });
+    this.writeArray(msg.unsetEnv, (val) => {
+      this.writeString(val);
+    });
+    this.writeInt(msg.scrollback);
+    this.writeBoolean(msg.dropScrollback);
   }
   writeKeyEvent(msg: KeyEvent) {
     this.writeInt(msg.cell);
     this.writeString(msg.keys);
   }
+  writePaste(msg: Paste) {
+    this.writeInt(msg.cell);
+    this.writeString(msg.text);
+  }
+  writeSignal(msg: Signal) {
+    this.writeInt(msg.cell);
+    this.writeString(msg.signal);
+  }
+  writeScrollbackRequest(msg: ScrollbackRequest) {
+    this.writeInt(msg.cell);
+    this.writeInt(msg.start);
+    this.writeInt(msg.end);
+  }
+  writeScrollbackResponse(msg: ScrollbackResponse) {
+    this.writeInt(msg.cell);
+    this.writeString(msg.error);
+    this.writeArray(msg.rows, (val) => {
+      this.writeRowSpans(val);
+    });
+  }
+  writeRowsRequest(msg: RowsRequest) {
+    this.writeInt(msg.cell);
+    this.writeInt(msg.start);
+    this.writeInt(msg.end);
+  }
+  writeSearchRequest(msg: SearchRequest) {
+    this.writeInt(msg.id);
+    this.writeString(msg.query);
+    this.writeBoolean(msg.regex);
+    this.writeBoolean(msg.ignoreCase);
+    this.writeArray(msg.cells, (val) => {
+      this.writeInt(val);
+    });
+  }
+  writeSearchMatch(msg: SearchMatch) {
+    this.writeInt(msg.cell);
+    this.writeInt(msg.row);
+    this.writeBoolean(msg.history);
+    this.writeInt(msg.start);
+    this.writeInt(msg.end);
+    this.writeString(msg.before);
+    this.writeString(msg.match);
+    this.writeString(msg.after);
+  }
+  writeSearchResponse(msg: SearchResponse) {
+    this.writeInt(msg.id);
+    this.writeString(msg.error);
+    this.writeArray(msg.matches, (val) => {
+      this.writeSearchMatch(val);
+    });
+    this.writeBoolean(msg.truncated);
+  }
+  writeResize(msg: Resize) {
+    this.writeInt(msg.cell);
+    this.writeTermSize(msg.size);
+  }
+  writeMouseEvent(msg: MouseEvent) {
+    this.writeInt(msg.cell);
+    this.writeUint8(msg.action);
+    this.writeUint8(msg.button);
+    this.writeInt(msg.row);
+    this.writeInt(msg.col);
+    this.writeBoolean(msg.shift);
+    this.writeBoolean(msg.alt);
+    this.writeBoolean(msg.ctrl);
+  }
   writeRowSpans(msg: RowSpans) {
     this.writeInt(msg.row);
     this.writeArray(msg.spans, (val) => {
@@ -315,6 +790,8 @@ export class Writer {
   }
   writeSpan(msg: Span) {
     this.writeInt(msg.attr);
+    this.writeInt(msg.fg);
+    this.writeInt(msg.bg);
     this.writeString(msg.text);
   }
   writeCursor(msg: Cursor) {
@@ -328,24 +805,64 @@ export class Writer {
     });
     this.writeCursor(msg.cursor);
     this.writeInt(msg.rowCount);
+    this.writeInt(msg.trimmed);
+    this.writeInt(msg.history);
+    this.writeBoolean(msg.altScreen);
+    this.writeUint8(msg.mouse);
+    this.writeBoolean(msg.appCursorKeys);
+    this.writeBoolean(msg.appKeypad);
+    this.writeBoolean(msg.bracketedPaste);
   }
   writePair(msg: Pair) {
     this.writeString(msg.key);
     this.writeString(msg.val);
   }
+  writeCmdError(msg: CmdError) {
+    this.writeString(msg.error);
+  }
+  writeExit(msg: Exit) {
+    this.writeInt(msg.exitCode);
+    this.writeString(msg.signal);
+    this.writeBoolean(msg.coreDumped);
+    this.writeInt(msg.wallMs);
+    this.writeInt(msg.userMs);
+    this.writeInt(msg.sysMs);
+    this.writeInt(msg.maxRssKb);
+  }
+  writeInputError(msg: InputError) {
+    this.writeString(msg.error);
+  }
+  writeSignalResult(msg: SignalResult) {
+    this.writeString(msg.signal);
+    this.writeString(msg.error);
+  }
+  writeCellState(msg: CellState) {
+    this.writeInt(msg.cell);
+    this.writeString(msg.cwd);
+    this.writeArray(msg.argv, (val) => {
+      this.writeString(val);
+    });
+    this.writeBoolean(msg.running);
+    this.writeString(msg.error);
+    this.writeExit(msg.exit);
+    this.writeTermUpdate(msg.term);
+  }
   writeHello(msg: Hello) {
+    this.writeString(msg.session);
     this.writeArray(msg.alias, (val) => {
       this.writePair(val);
     });
     this.writeArray(msg.env, (val) => {
       this.writePair(val);
     });
+    this.writeArray(msg.cells, (val) => {
+      this.writeCellState(val);
+    });
   }
-  writeCmdError(msg: CmdError) {
-    this.writeString(msg.error);
-  }
-  writeExit(msg: Exit) {
-    this.writeInt(msg.exitCode);
+  writeRowsResponse(msg: RowsResponse) {
+    this.writeArray(msg.rows, (val) => {
+      this.writeRowSpans(val);
+    });
   }
   writeOutput(msg: Output) {
     switch (msg.tag) {
@@ -361,6 +878,18 @@ export class Writer {
         this.writeUint8(3);
         this.writeExit(msg.val);
         break;
+      case 'SignalResult':
+        this.writeUint8(4);
+        this.writeSignalResult(msg.val);
+        break;
+      case 'InputError':
+        this.writeUint8(5);
+        this.writeInputError(msg.val);
+        break;
+      case 'RowsResponse':
+        this.writeUint8(6);
+        this.writeRowsResponse(msg.val);
+        break;
     }
   }
   writeCellOutput(msg: CellOutput) {
@@ -381,6 +910,54 @@ export class Writer {
         this.writeUint8(3);
         this.writeCellOutput(msg.val);
         break;
+      case 'ScrollbackResponse':
+        this.writeUint8(4);
+        this.writeScrollbackResponse(msg.val);
+        break;
+      case 'SearchResponse':
+        this.writeUint8(5);
+        this.writeSearchResponse(msg.val);
+        break;
+    }
+  }
+  writeLocalRequest(msg: LocalRequest) {
+    this.writeString(msg.command);
+    this.writeArray(msg.args, (val) => {
+      this.writeString(val);
+    });
+  }
+  writeLocalStdin(msg: LocalStdin) {
+    this.writeBytes(msg.data);
+  }
+  writeLocalClientMsg(msg: LocalClientMsg) {
+    switch (msg.tag) {
+      case 'LocalRequest':
+        this.writeUint8(1);
+        this.writeLocalRequest(msg.val);
+        break;
+      case 'LocalStdin':
+        this.writeUint8(2);
+        this.writeLocalStdin(msg.val);
+        break;
+    }
+  }
+  writeLocalOutput(msg: LocalOutput) {
+    this.writeBoolean(msg.stderr);
+    this.writeBytes(msg.data);
+  }
+  writeLocalExit(msg: LocalExit) {
+    this.writeInt(msg.code);
+  }
+  writeLocalServerMsg(msg: LocalServerMsg) {
+    switch (msg.tag) {
+      case 'LocalOutput':
+        this.writeUint8(1);
+        this.writeLocalOutput(msg.val);
+        break;
+      case 'LocalExit':
+        this.writeUint8(2);
+        this.writeLocalExit(msg.val);
+        break;
     }
   }
 }
@@ -0,0 +1,121 @@
+import { html } from './html';
+import * as proto from './proto';
+import { renderRow, revealRow } from './term';
+
+/** Lines requested each time the user asks for earlier output. */
+const PAGE_LINES = 500;
+
+/**
+ * Output trimmed from a cell's terminal into the server's scrollback
+ * store, fetched a page at a time when the user asks for it.
+ */
+export class Scrollback {
+  dom = html('div', { className: 'scrollback' });
+  private button = html('button', { onclick: () => this.fetch() });
+  private rows = html('pre');
+  /** Lines stored on the server. */
+  private history = 0;
+  /**
+   * Whether any lines are shown.  Once they are, lines trimmed from the
+   * terminal are moved here so the output stays contiguous.
+   */
+  private shown = false;
+  /** The range [start, end) of stored lines shown. */
+  private start = 0;
+  private end = 0;
+  /** Whether a request is outstanding. */
+  private pending = false;
+  /** A stored line to reveal once it has been fetched, or -1. */
+  private revealing = -1;
+
+  delegates = {
+    send: (msg: proto.ClientMessage) => {},
+  };
+
+  constructor(readonly cell: number) {
+    this.dom.appendChild(this.button);
+    this.dom.appendChild(this.rows);
+    this.render();
+  }
+
+  /**
+   * Takes rows trimmed from the terminal by an update, along with the new
+   * count of stored lines.
+   */
+  onTrim(rows: HTMLElement[], history: number) {
+    if (this.shown) {
+      if (this.end === this.history) {
+        for (const row of rows) this.rows.appendChild(row);
+        this.end += rows.length;
+      } else {
+        // Lines were trimmed while we weren't watching, e.g. while
+        // disconnected, so what's shown no longer leads up to the terminal.
+        this.rows.innerText = '';
+        this.shown = false;
+      }
+    }
+    this.history = history;
+    this.render();
+  }
+
+  /** Requests the page of lines before those shown. */
+  private fetch() {
+    if (this.pending) return;
+    if (!this.shown) {
+      this.shown = true;
+      this.start = this.end = this.history;
+    }
+    const start = Math.max(0, this.start - PAGE_LINES);
+    this.delegates.send({
+      tag: 'ScrollbackRequest',
+      val: { cell: this.cell, start, end: this.start },
+    });
+    this.pending = true;
+    this.render();
+  }
+
+  /**
+   * Shows a stored line, as found by a search, fetching pages until it
+   * is reached.
+   */
+  reveal(line: number) {
+    if (this.shown && line >= this.start && line < this.end) {
+      revealRow(this.rows.children[line - this.start] as HTMLElement);
+      return;
+    }
+    this.revealing = line;
+    this.fetch();
+  }
+
+  onResponse(msg: proto.ScrollbackResponse) {
+    this.pending = false;
+    const revealing = this.revealing;
+    this.revealing = -1;
+    if (msg.error) {
+      this.render();
+      this.button.innerText = msg.error;
+      return;
+    }
+    // Rows are only useful if they lead up to those already shown.
+    const last = msg.rows[msg.rows.length - 1];
+    if (last && last.row === this.start - 1) {
+      const first = this.rows.firstChild;
+      for (const row of msg.rows) {
+        const el = html('div');
+        renderRow(el, row.spans);
+        this.rows.insertBefore(el, first);
+      }
+      this.start = msg.rows[0].row;
+      if (revealing >= 0) this.reveal(revealing);
+    }
+    this.render();
+  }
+
+  private render() {
+    const earlier = this.shown ? this.start : this.history;
+    this.dom.style.display = earlier > 0 || this.shown ? '' : 'none';
+    this.button.style.display = earlier > 0 ? '' : 'none';
+    this.button.disabled = this.pending;
+    this.button.innerText = `${earlier} earlier lines`;
+  }
+}
@@ -7,10 +7,22 @@ export function parseCmd(cmd: string): string[] {
   return parts;
 }
 
+/** Recovers the command line as typed from the argv of a remote command. */
+export function argvToCmd(argv: string[]): string {
+  // Inverse of the wrapping done in Shell.exec().
+  if (argv.length === 3 && argv[0] === '/bin/sh' && argv[1] === '-c') {
+    return argv[2];
+  }
+  return argv.join(' ');
+}
+
 export interface ExecRemote {
   kind: 'remote';
   cwd: string;
   cmd: string[];
+  /** Environment variables changed from the server's environment. */
+  setEnv?: Array<[string, string]>;
+  unsetEnv?: string[];
   onComplete?: (exitCode: number) => void;
 }
 
@@ -25,7 +37,17 @@ export interface StringOutput {
   output: string;
 }
 
-export type ExecOutput = ExecRemote | TableOutput | StringOutput;
+/** A search of the output of the session's cells, done by the server. */
+export interface SearchQuery {
+  kind: 'search';
+  query: string;
+  regex: boolean;
+  ignoreCase: boolean;
+  /** Cells to search; all cells if empty. */
+  cells: number[];
+}
+
+export type ExecOutput = ExecRemote | TableOutput | StringOutput | SearchQuery;
 
 function strOutput(msg: string): ExecOutput {
   return { kind: 'string', output: msg };
@@ -34,6 +56,12 @@ function strOutput(msg: string): ExecOutput {
 export class Shell {
   aliases = new AliasMap();
   cwd = '/';
+  /**
+   * Environment variables changed by export and unset, which must be
+   * passed along to remote commands.  this.env reflects these changes.
+   */
+  exported = new Map<string, string>();
+  unexported = new Set<string>();
 
   constructor(public env = new Map<string, string>()) {}
 
@@ -42,8 +70,7 @@ export class Shell {
     this.aliases.set('that', `${this.env.get('SMASH')} that`);
   }
 
-  cwdForPrompt() {
-    let cwd = this.cwd;
+  cwdForPrompt(cwd = this.cwd) {
     const home = this.env.get('HOME');
     if (home && cwd.startsWith(home)) {
       cwd = '~' + cwd.substring(home.length);
@@ -78,6 +105,77 @@ export class Shell {
     };
   }
 
+  builtinExport(argv: string[]): ExecOutput {
+    if (argv.length === 0) return this.envTable();
+    for (const arg of argv) {
+      const eq = arg.indexOf('=');
+      if (eq <= 0) {
+        return strOutput('usage: export NAME=VALUE...');
+      }
+    }
+    for (const arg of argv) {
+      const eq = arg.indexOf('=');
+      const name = arg.substring(0, eq);
+      const value = arg.substring(eq + 1);
+      this.env.set(name, value);
+      this.exported.set(name, value);
+      this.unexported.delete(name);
+    }
+    return strOutput('');
+  }
+
+  builtinUnset(argv: string[]): ExecOutput {
+    if (argv.length === 0) {
+      return strOutput('usage: unset NAME...');
+    }
+    for (const name of argv) {
+      this.env.delete(name);
+      this.exported.delete(name);
+      this.unexported.add(name);
+    }
+    return strOutput('');
+  }
+
+  builtinSearch(argv: string[]): ExecOutput {
+    const usage = strOutput('usage: search [-r] [-i] [-c CELL]... TEXT...');
+    const search: SearchQuery = {
+      kind: 'search',
+      query: '',
+      regex: false,
+      ignoreCase: false,
+      cells: [],
+    };
+    let i = 0;
+    for (; i < argv.length; i++) {
+      const arg = argv[i];
+      if (arg === '-r') {
+        search.regex = true;
+      } else if (arg === '-i') {
+        search.ignoreCase = true;
+      } else if (arg === '-c') {
+        const cell = parseInt(argv[++i], 10);
+        if (!(cell >= 0)) return usage;
+        search.cells.push(cell);
+      } else {
+        if (arg === '--') i++;
+        break;
+      }
+    }
+    // The parser doesn't preserve whitespace, so words are rejoined with
+    // single spaces.
+    search.query = argv.slice(i).join(' ');
+    if (!search.query) return usage;
+    return search;
+  }
+
+  private envTable(): ExecOutput {
+    return {
+      kind: 'table',
+      headers: ['var', 'value'],
+      rows: Array.from(this.env),
+    };
+  }
+
   private handleBuiltin(argv: string[]): ExecOutput | undefined {
     switch (argv[0]) {
       case 'alias':
@@ -96,11 +194,13 @@ export class Shell {
         return this.builtinCd(argv.slice(1));
       case 'env':
         if (argv.length > 1) return;
-        return {
-          kind: 'table',
-          headers: ['var', 'value'],
-          rows: Array.from(this.env),
-        };
+        return this.envTable();
+      case 'export':
+        return this.builtinExport(argv.slice(1));
+      case 'unset':
+        return this.builtinUnset(argv.slice(1));
+      case 'search':
+        return this.builtinSearch(argv.slice(1));
     }
   }
 
@@ -110,6 +210,12 @@ export class Shell {
     const argv = parseCmd(cmd);
     const out = this.handleBuiltin(argv);
     if (out) return out;
-    return { kind: 'remote', cwd: this.cwd, cmd: ['/bin/sh', '-c', cmd] };
+    return {
+      kind: 'remote',
+      cwd: this.cwd,
+      cmd: ['/bin/sh', '-c', cmd],
+      setEnv: Array.from(this.exported),
+      unsetEnv: Array.from(this.unexported),
+    };
   }
 }
@@ -33,6 +33,54 @@ describe('shell', async function () {
     expect(sh.cwdForPrompt()).equal('~/test');
   });
 
+  describe('export', function () {
+    it('sets and unsets variables', function () {
+      const sh = new Shell(new Map(env));
+      sh.exec('export FOO=bar BAZ=a=b');
+      expect(sh.env.get('FOO')).equal('bar');
+      expect(sh.env.get('BAZ')).equal('a=b');
+      sh.exec('unset FOO HOME');
+      expect(sh.env.has('FOO')).equal(false);
+      expect(sh.env.has('HOME')).equal(false);
+
+      const out = sh.exec('ls');
+      if (out.kind !== 'remote') throw new Error('expected remote');
+      expect(out.setEnv).deep.equal([['BAZ', 'a=b']]);
+      expect(out.unsetEnv).deep.equal(['FOO', 'HOME']);
+    });
+
+    it('rejects bad arguments', function () {
+      const sh = new Shell(new Map(env));
+      const out = sh.exec('export FOO');
+      expect(out.kind).equal('string');
+      expect(sh.env.has('FOO')).equal(false);
+    });
+  });
+
+  describe('search', function () {
+    it('parses flags', function () {
+      const sh = new Shell(new Map(env));
+      expect(sh.exec('search -i -c 3 -c 4 no such file')).deep.equal({
+        kind: 'search',
+        query: 'no such file',
+        regex: false,
+        ignoreCase: true,
+        cells: [3, 4],
+      });
+      const out = sh.exec('search -r -- -[0-9]+');
+      if (out.kind !== 'search') throw new Error('expected search');
+      expect(out.regex).equal(true);
+      expect(out.query).equal('-[0-9]+');
+    });
+
+    it('rejects bad arguments', function () {
+      const sh = new Shell(new Map(env));
+      expect(sh.exec('search').kind).equal('string');
+      expect(sh.exec('search -i').kind).equal('string');
+      expect(sh.exec('search -c x foo').kind).equal('string');
+    });
+  });
+
   describe('cd', function () {
     it('goes home', async function () {
       const sh = new Shell(env);
@@ -9,13 +9,18 @@ async function connect() {
   const conn = new ServerConnection();
   const hello = await conn.connect();
 
-  const shell = new Shell();
-  shell.aliases.replaceAll(
-    new Map<string, string>(hello.alias.map(({ key, val }) => [key, val]))
-  );
-  shell.env = new Map(hello.env.map(({ key, val }) => [key, val]));
-  shell.init();
-  tabs.addCells(shell);
+  if (tabs.tabs.length === 0) {
+    const shell = new Shell();
+    shell.aliases.replaceAll(
+      new Map<string, string>(hello.alias.map(({ key, val }) => [key, val]))
+    );
+    shell.env = new Map(hello.env.map(({ key, val }) => [key, val]));
+    shell.init();
+    tabs.addCells(shell, hello.cells);
+  } else {
+    // Reconnected; catch up on output missed while disconnected.
+    tabs.restore(hello.cells);
+  }
   tabs.focus();
 
   tabs.delegates = {
@@ -74,6 +79,7 @@ async function main() {
       tabs.focus();
     }
   });
+  window.addEventListener('resize', () => tabs.onResize());
 
   for (;;) {
     try {
@@ -21,8 +21,8 @@ export class Tabs {
     send: (msg: proto.ClientMessage) => {},
   };
 
-  addCells(shell: Shell) {
-    const tab = this.newTab(shell);
+  addCells(shell: Shell, cells: proto.CellState[] = []) {
+    const tab = this.newTab(shell, cells);
     this.tabs.push(tab);
     this.tabStrip.appendChild(tab.dom);
 
@@ -35,15 +35,26 @@ export class Tabs {
     }
   }
 
-  private newTab(shell: Shell): Tab {
+  private newTab(shell: Shell, cells: proto.CellState[]): Tab {
     const dom = html('div', { className: 'tab' }, htext('tab'));
-    const cellStack = new CellStack(shell);
+    const cellStack = new CellStack(shell, cells);
     cellStack.delegates = {
       send: (msg) => this.delegates.send(msg),
     };
     return { dom, cellStack };
   }
 
+  /** Updates cells after reconnecting to the server session. */
+  restore(cells: proto.CellState[]) {
+    this.tabs[0].cellStack.restore(cells);
+  }
+
+  onResize() {
+    for (const tab of this.tabs) {
+      tab.cellStack.onResize();
+    }
+  }
+
   handleMessage(msg: proto.ServerMsg): boolean {
     const cellStack = this.tabs[0].cellStack;
     switch (msg.tag) {
@@ -53,6 +64,12 @@ export class Tabs {
       case 'CellOutput':
         cellStack.onOutput(msg.val);
         return true;
+      case 'ScrollbackResponse':
+        cellStack.onScrollback(msg.val);
+        return true;
+      case 'SearchResponse':
+        cellStack.onSearch(msg.val);
+        return true;
     }
     return false;
   }
@@ -2,25 +2,99 @@ import { html, htext } from './html';
 import * as proto from './proto';
 import { translateKey } from './readline';
 
-interface Attr {
-  fg: number;
-  bg: number;
-  bright: boolean;
+/** Flag bit for direct RGB colors, as in vt100.ColorRGB. */
+const COLOR_RGB = 1 << 24;
+
+/** Flag bits of Span.attr, as in vt100.Attr.Flags. */
+const ATTR_BRIGHT = 1 << 0;
+const ATTR_INVERSE = 1 << 1;
+const ATTR_FAINT = 1 << 2;
+const ATTR_ITALIC = 1 << 3;
+const ATTR_UNDERLINE_SHIFT = 4;
+const ATTR_BLINK = 1 << 7;
+const ATTR_HIDDEN = 1 << 8;
+const ATTR_STRIKETHROUGH = 1 << 9;
+
+/** CSS text-decoration-style for each underline style in Span.attr. */
+const UNDERLINE_STYLES = ['', 'solid', 'double', 'wavy', 'dotted', 'dashed'];
+
+/** Applies the Span.attr flags to an element. */
+function applyFlags(el: HTMLElement, attr: number) {
+  if (attr & ATTR_BRIGHT) el.classList.add('bright');
+  if (attr & ATTR_INVERSE) el.classList.add('inverse');
+  if (attr & ATTR_FAINT) el.classList.add('faint');
+  if (attr & ATTR_ITALIC) el.classList.add('italic');
+  if (attr & ATTR_BLINK) el.classList.add('blink');
+  if (attr & ATTR_HIDDEN) el.classList.add('hidden');
+  // Underline and strikethrough share text-decoration, so they can't be
+  // independent classes.
+  const lines: string[] = [];
+  const underline = (attr >> ATTR_UNDERLINE_SHIFT) & 0b111;
+  if (underline) {
+    lines.push('underline');
+    el.style.textDecorationStyle = UNDERLINE_STYLES[underline] || 'solid';
+  }
+  if (attr & ATTR_STRIKETHROUGH) lines.push('line-through');
+  if (lines.length > 0) el.style.textDecorationLine = lines.join(' ');
 }
 
-/** Decodes a packed attribute number as described in terminal.go. */
-function decodeAttr(attr: number): Attr {
-  const fg = attr & 0b1111;
-  const bg = (attr & 0b11110000) >> 4;
-  const bright = (attr & 0x0100) !== 0;
-  return { fg, bg, bright };
+/** The xterm palette levels used for the 6x6x6 color cube. */
+const CUBE_LEVELS = [0, 0x5f, 0x87, 0xaf, 0xd7, 0xff];
+
+/** Computes the CSS color for a palette color beyond the first 16. */
+function indexedCSS(index: number): string {
+  if (index >= 232) {
+    const level = 8 + (index - 232) * 10;
+    return `rgb(${level}, ${level}, ${level})`;
+  }
+  index -= 16;
+  const r = CUBE_LEVELS[Math.floor(index / 36)];
+  const g = CUBE_LEVELS[Math.floor(index / 6) % 6];
+  const b = CUBE_LEVELS[index % 6];
+  return `rgb(${r}, ${g}, ${b})`;
+}
+
+/**
+ * Applies an encoded color (see proto.Span) to an element.  The 16 basic
+ * colors use CSS classes so they follow the theme; others are inline.
+ */
+function applyColor(el: HTMLElement, prop: 'fg' | 'bg', color: number) {
+  if (color === 0) return;
+  if (color <= 16) {
+    el.classList.add(`${prop}${color}`);
+    return;
+  }
+  let css: string;
+  if (color & COLOR_RGB) {
+    const rgb = color & 0xffffff;
+    css = `rgb(${rgb >> 16}, ${(rgb >> 8) & 0xff}, ${rgb & 0xff})`;
+  } else {
+    css = indexedCSS(color - 1);
+  }
+  if (prop === 'fg') {
+    el.style.color = css;
+  } else {
+    el.style.background = css;
+  }
 }
 
+/** Values of proto.MouseEvent.action. */
+const MOUSE_PRESS = 0;
+const MOUSE_RELEASE = 1;
+const MOUSE_MOVE = 2;
+
+/** Values of proto.MouseEvent.button beyond the DOM's 0-2. */
+const MOUSE_NO_BUTTON = 3;
+const MOUSE_WHEEL_UP = 4;
+const MOUSE_WHEEL_DOWN = 5;
+
 const termKeyMap: { [key: string]: string } = {
   ArrowUp: '\x1b[A',
   ArrowDown: '\x1b[B',
   ArrowRight: '\x1b[C',
   ArrowLeft: '\x1b[D',
+  Home: '\x1b[H',
+  End: '\x1b[F',
 
   Backspace: '\x08',
   Tab: '\x09',
@@ -29,6 +103,68 @@ const termKeyMap: { [key: string]: string } = {
   Escape: '\x1b',
 };
 
+/** Keys that send SS3 sequences in application cursor keys mode. */
+const appCursorKeyMap: { [key: string]: string } = {
+  ArrowUp: '\x1bOA',
+  ArrowDown: '\x1bOB',
+  ArrowRight: '\x1bOC',
+  ArrowLeft: '\x1bOD',
+  Home: '\x1bOH',
+  End: '\x1bOF',
+};
+
+/**
+ * Keypad keys, by KeyboardEvent.code, that send SS3 sequences in
+ * application keypad mode.  As in xterm with NumLock on, the digits
+ * still send digits.
+ */
+const appKeypadMap: { [code: string]: string } = {
+  NumpadEnter: '\x1bOM',
+  NumpadMultiply: '\x1bOj',
+  NumpadAdd: '\x1bOk',
+  NumpadSubtract: '\x1bOm',
+  NumpadDecimal: '\x1bOn',
+  NumpadDivide: '\x1bOo',
+  NumpadEqual: '\x1bOX',
+};
+
+/**
+ * Rows to fetch on either side of the unloaded rows scrolled into view,
+ * so that scrolling doesn't fetch a few rows at a time.
+ */
+const ROWS_MARGIN = 100;
+
+/** Scrolls a row into view and briefly highlights it. */
+export function revealRow(el: HTMLElement) {
+  el.scrollIntoView({ block: 'center' });
+  el.classList.add('found');
+  setTimeout(() => el.classList.remove('found'), 2000);
+}
+
+/** Renders a row of terminal output into el, replacing its contents. */
+export function renderRow(el: HTMLElement, spans: proto.Span[]) {
+  if (spans.length === 0) {
+    // Empty line. Set text to something non-empty so the div isn't
+    // collapsed.
+    el.innerText = ' ';
+    return;
+  }
+  el.innerText = '';
+  for (const span of spans) {
+    const hspan = html('span');
+    applyFlags(hspan, span.attr);
+    if (span.attr & ATTR_INVERSE) {
+      applyColor(hspan, 'fg', span.bg);
+      applyColor(hspan, 'bg', span.fg);
+    } else {
+      applyColor(hspan, 'fg', span.fg);
+      applyColor(hspan, 'bg', span.bg);
+    }
+    hspan.innerText = span.text;
+    el.appendChild(hspan);
+  }
+}
+
 /**
  * Client side DOM of terminal emulation.
  *
@@ -39,15 +175,40 @@ export class Term {
   dom = html('pre', { tabIndex: 0, className: 'term' });
   cursor = html('div', { className: 'term-cursor' });
   cellSize = { width: 0, height: 0 };
+  /** Mouse reporting requested by the subprocess, as in TermUpdate. */
+  mouseMode = 0;
+  /** The mouse button held down, for reporting drags. */
+  mouseButton = MOUSE_NO_BUTTON;
+  /** Key modes requested by the subprocess, as in TermUpdate. */
+  appCursorKeys = false;
+  appKeypad = false;
+  /**
+   * Watches placeholders for rows the server hasn't sent, which have the
+   * 'unloaded' class, to fetch them when they scroll into view.
+   */
+  private unloaded = new IntersectionObserver((entries) =>
+    this.onUnloadedVisible(entries)
+  );
 
   delegates = {
     /** Sends a keyboard event to the terminal's subprocess. */
     key: (msg: proto.KeyEvent) => {},
+    /** Sends a mouse event to the terminal's subprocess. */
+    mouse: (msg: proto.MouseEvent) => {},
+    /** Sends pasted text to the terminal's subprocess. */
+    paste: (msg: proto.Paste) => {},
+    /** Requests rows that haven't been loaded. */
+    rows: (msg: proto.RowsRequest) => {},
   };
 
   constructor() {
     this.dom.onkeydown = (e) => this.onKeyDown(e);
     this.dom.onkeypress = (e) => this.onKeyPress(e);
+    this.dom.onmousedown = (e) => this.onMouse(e, MOUSE_PRESS);
+    this.dom.onmouseup = (e) => this.onMouse(e, MOUSE_RELEASE);
+    this.dom.onmousemove = (e) => this.onMouse(e, MOUSE_MOVE);
+    this.dom.onwheel = (e) => this.onWheel(e);
+    this.dom.onpaste = (e) => this.onPaste(e);
     this.dom.appendChild(this.cursor);
     this.measure();
     // Create initial empty line, for height.
@@ -67,6 +228,17 @@ export class Term {
     this.cellSize.height = Number(height!.replace('px', ''));
   }
 
+  /**
+   * Computes the terminal size that fills the window width and height.
+   * The terminal is always as wide as the page, so measure the page rather
+   * than this.dom, which may not be attached yet.
+   */
+  fitSize(): proto.TermSize {
+    const cols = Math.floor(document.body.clientWidth / this.cellSize.width);
+    const rows = Math.floor(window.innerHeight / this.cellSize.height);
+    return { rows: Math.max(rows, 1), cols: Math.max(cols, 1) };
+  }
+
   focus() {
     this.dom.focus();
   }
@@ -82,27 +254,11 @@ export class Term {
       const row = rowSpans.row;
       for (; childIdx < row; childIdx++) {
         if (!child.nextSibling) {
-          this.dom.appendChild(html('div', {}, htext(' ')));
+          this.dom.appendChild(this.placeholderRow());
         }
         child = child.nextSibling! as HTMLElement;
       }
-      const spans = rowSpans.spans;
-      if (spans.length === 0) {
-        // Empty line. Set text to something non-empty so the div isn't
-        // collapsed.
-        child.innerText = ' ';
-      } else {
-        child.innerText = '';
-        for (const span of spans) {
-          const { fg, bg, bright } = decodeAttr(span.attr);
-          const hspan = html('span');
-          if (bright) hspan.classList.add(`bright`);
-          if (fg > 0) hspan.classList.add(`fg${fg}`);
-          if (bg > 0) hspan.classList.add(`bg${bg}`);
-          hspan.innerText = span.text;
-          child.appendChild(hspan);
-        }
-      }
+      this.setRow(child, rowSpans.spans);
     }
     const cursor = msg.cursor;
     if (cursor) {
@@ -111,7 +267,98 @@ export class Term {
       this.cursor.style.top = cursor.row * this.cellSize.height + 'px';
     }
     while (this.dom.childElementCount > msg.rowCount + 1) {
-      this.dom.removeChild(this.dom.lastChild!);
+      const row = this.dom.removeChild(this.dom.lastChild!) as Element;
+      this.unloaded.unobserve(row);
+    }
+    this.dom.classList.toggle('alt-screen', msg.altScreen);
+    this.mouseMode = msg.mouse;
+    this.appCursorKeys = msg.appCursorKeys;
+    this.appKeypad = msg.appKeypad;
+  }
+
+  /**
+   * Removes the first n rows, as trimmed into the server's scrollback by
+   * TermUpdate.trimmed, returning the removed row elements.
+   */
+  trim(n: number): HTMLElement[] {
+    const rows: HTMLElement[] = [];
+    while (rows.length < n && this.dom.childElementCount > 2) {
+      const row = this.dom.removeChild(this.dom.children[1]) as HTMLElement;
+      this.unloaded.unobserve(row);
+      rows.push(row);
+    }
+    if (rows.length > 0) {
+      // Requests in flight were for the old row numbers, so may not cover
+      // the placeholders; observing afresh requests them again if visible.
+      for (const el of Array.from(this.dom.querySelectorAll('.unloaded'))) {
+        delete (el as HTMLElement).dataset.requested;
+        this.unloaded.unobserve(el);
+        this.unloaded.observe(el);
+      }
+    }
+    return rows;
+  }
+
+  /**
+   * Discards all rows, for a snapshot of the terminal to replace them.
+   * Rows the snapshot leaves out become placeholders.
+   */
+  clearRows() {
+    this.unloaded.disconnect();
+    this.dom.innerText = '';
+    this.dom.appendChild(this.cursor);
+    this.dom.appendChild(html('div', {}, htext(' ')));
+  }
+
+  /** Creates a placeholder for a row that hasn't been sent. */
+  private placeholderRow(): HTMLElement {
+    const el = html('div', { className: 'unloaded' }, htext(' '));
+    this.unloaded.observe(el);
+    return el;
+  }
+
+  /** Renders a row, replacing any placeholder. */
+  private setRow(el: HTMLElement, spans: proto.Span[]) {
+    if (el.classList.contains('unloaded')) {
+      el.classList.remove('unloaded');
+      this.unloaded.unobserve(el);
+    }
+    renderRow(el, spans);
+  }
+
+  private onUnloadedVisible(entries: IntersectionObserverEntry[]) {
+    const children = Array.from(this.dom.children);
+    let start = Infinity;
+    let end = -1;
+    for (const entry of entries) {
+      const el = entry.target as HTMLElement;
+      if (!entry.isIntersecting || el.dataset.requested) continue;
+      const row = children.indexOf(el) - 1; // skip this.cursor
+      if (row < 0) continue;
+      start = Math.min(start, row);
+      end = Math.max(end, row + 1);
+    }
+    if (end < 0) return;
+    start = Math.max(0, start - ROWS_MARGIN);
+    end = Math.min(children.length - 1, end + ROWS_MARGIN);
+    for (let row = start; row < end; row++) {
+      const el = children[row + 1] as HTMLElement;
+      if (el.classList.contains('unloaded')) el.dataset.requested = '1';
+    }
+    this.delegates.rows({ cell: 0, start, end });
+  }
+
+  /** Scrolls a row into view and briefly highlights it. */
+  revealRow(row: number) {
+    const el = this.dom.children[row + 1] as HTMLElement | undefined;
+    if (el) revealRow(el);
+  }
+
+  /** Fills in rows fetched with a RowsRequest. */
+  onRows(msg: proto.RowsResponse) {
+    for (const row of msg.rows) {
+      const el = this.dom.children[row.row + 1] as HTMLElement | undefined;
+      if (el) this.setRow(el, row.spans);
     }
   }
 
@@ -123,6 +370,63 @@ export class Term {
     return this.delegates.key({ cell: 0, keys });
   }
 
+  /**
+   * Forwards a mouse event if the subprocess asked for mouse reporting.
+   * The server decides which events the subprocess wants.
+   */
+  onMouse(ev: MouseEvent, action: number) {
+    if (this.mouseMode === 0) return;
+    let button = ev.button;
+    if (action === MOUSE_PRESS) {
+      this.mouseButton = button;
+    } else if (action === MOUSE_RELEASE) {
+      this.mouseButton = MOUSE_NO_BUTTON;
+    } else {
+      // Only send the motion the subprocess asked for: drags in mode 3, and
+      // any motion in mode 4.
+      const dragging = this.mouseButton !== MOUSE_NO_BUTTON;
+      if (this.mouseMode < 3 || (this.mouseMode === 3 && !dragging)) return;
+      button = this.mouseButton;
+    }
+    this.sendMouse(ev, action, button);
+    if (action === MOUSE_PRESS) {
+      // Keep focus for keyboard input, but avoid starting a selection.
+      this.focus();
+    }
+    ev.preventDefault();
+  }
+
+  onWheel(ev: WheelEvent) {
+    if (this.mouseMode === 0 || ev.deltaY === 0) return;
+    const button = ev.deltaY < 0 ? MOUSE_WHEEL_UP : MOUSE_WHEEL_DOWN;
+    this.sendMouse(ev, MOUSE_PRESS, button);
+    ev.preventDefault();
+  }
+
+  sendMouse(ev: MouseEvent, action: number, button: number) {
+    const rect = this.dom.getBoundingClientRect();
+    const col = Math.floor((ev.clientX - rect.left) / this.cellSize.width);
+    const row = Math.floor((ev.clientY - rect.top) / this.cellSize.height);
+    if (row < 0 || col < 0) return;
+    this.delegates.mouse({
+      cell: 0,
+      action,
+      button,
+      row,
+      col,
+      shift: ev.shiftKey,
+      alt: ev.altKey,
+      ctrl: ev.ctrlKey,
+    });
+  }
+
+  onPaste(ev: ClipboardEvent) {
+    const text = ev.clipboardData?.getData('text');
+    if (!text) return;
+    this.delegates.paste({ cell: 0, text });
+    ev.preventDefault();
+  }
+
   onKeyDown(ev: KeyboardEvent) {
     let send: string | undefined;
     if (!ev.altKey && !ev.metaKey && ev.ctrlKey && ev.key.length === 1) {
@@ -132,8 +436,13 @@ export class Term {
         send = String.fromCharCode(code + 1);
       }
     }
+    if (!send && this.appKeypad && !ev.ctrlKey && !ev.altKey) {
+      send = appKeypadMap[ev.code];
+    }
     if (!send) {
-      send = termKeyMap[translateKey(ev)];
+      const key = translateKey(ev);
+      if (this.appCursorKeys) send = appCursorKeyMap[key];
+      if (!send) send = termKeyMap[key];
     }
     if (!send) return;
     this.sendKeys(send);